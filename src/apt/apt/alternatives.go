@@ -0,0 +1,250 @@
+package apt
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// alternative is one candidate registered with update-alternatives --install
+// for the link group Name.
+type alternative struct {
+	Link     string
+	Name     string
+	Path     string
+	Priority int
+	Slaves   []alternativeSlave
+}
+
+type alternativeSlave struct {
+	Link string
+	Name string
+	Path string
+}
+
+// jinfoLinks are the entries of a Java .jinfo file that are registered as
+// alternatives of /usr/bin/<name>.
+var jinfoLinks = map[string]bool{"hl": true, "jre": true, "jre-headless": true, "jdk": true, "jdkhl": true}
+
+// installAlternatives emulates update-alternatives, which packages call from
+// their postinst: for each link group the candidate with the highest
+// priority, or the one chosen in apt.yml, is linked through etc/alternatives
+// inside the install dir.
+func (a *Apt) installAlternatives(installed []*debContents) error {
+	var candidates []alternative
+	for _, contents := range installed {
+		candidates = append(candidates, parsePostinstAlternatives(contents.Postinst)...)
+	}
+
+	jinfos, _ := filepath.Glob(filepath.Join(a.installDir, "usr", "lib", "jvm", ".*.jinfo"))
+	for _, jinfo := range jinfos {
+		found, err := parseJinfo(jinfo)
+		if err != nil {
+			return err
+		}
+		candidates = append(candidates, found...)
+	}
+
+	groups := map[string][]alternative{}
+	for _, candidate := range candidates {
+		if exists, _ := libbuildpack.FileExists(filepath.Join(a.installDir, candidate.Path)); exists {
+			groups[candidate.Name] = append(groups[candidate.Name], candidate)
+		}
+	}
+
+	for name := range a.Alternatives {
+		if _, ok := groups[name]; !ok {
+			a.logger.Warning("The alternative %s set in apt.yml is not provided by any installed package", name)
+		}
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		selected, err := a.selectAlternative(name, groups[name])
+		if err != nil {
+			return err
+		}
+
+		a.logger.Info("Using %s for %s (alternative %s)", selected.Path, selected.Link, name)
+		if err := a.linkAlternative(selected.Link, selected.Name, selected.Path); err != nil {
+			return err
+		}
+		for _, slave := range selected.Slaves {
+			if exists, _ := libbuildpack.FileExists(filepath.Join(a.installDir, slave.Path)); exists {
+				if err := a.linkAlternative(slave.Link, slave.Name, slave.Path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func (a *Apt) selectAlternative(name string, candidates []alternative) (alternative, error) {
+	if path, ok := a.Alternatives[name]; ok {
+		var paths []string
+		for _, candidate := range candidates {
+			if candidate.Path == path {
+				return candidate, nil
+			}
+			paths = append(paths, candidate.Path)
+		}
+		return alternative{}, fmt.Errorf("alternative %s is set to %s in apt.yml, but the installed candidates are: %s", name, path, strings.Join(paths, ", "))
+	}
+
+	selected := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.Priority > selected.Priority {
+			selected = candidate
+		}
+	}
+	return selected, nil
+}
+
+// linkAlternative creates link -> etc/alternatives/name -> path, relative
+// inside the install dir, without replacing files that are not symlinks.
+func (a *Apt) linkAlternative(link, name, path string) error {
+	alternativesLink := filepath.Join(a.installDir, "etc", "alternatives", name)
+
+	for _, pair := range [][2]string{
+		{alternativesLink, filepath.Join(a.installDir, path)},
+		{filepath.Join(a.installDir, link), alternativesLink},
+	} {
+		from, to := pair[0], pair[1]
+
+		if info, err := os.Lstat(from); err == nil && info.Mode()&os.ModeSymlink == 0 {
+			a.logger.Warning("Not linking alternative %s, %s is not a symlink", name, link)
+			return nil
+		}
+
+		relative, err := filepath.Rel(filepath.Dir(from), to)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(from), 0755); err != nil {
+			return err
+		}
+		if err := replace(from, func(tmp string) error { return os.Symlink(relative, tmp) }); err != nil {
+			return fmt.Errorf("could not link alternative %s: %s", name, err)
+		}
+	}
+
+	return nil
+}
+
+// parsePostinstAlternatives finds the update-alternatives --install calls
+// of a postinst script whose arguments are literal.
+func parsePostinstAlternatives(postinst string) []alternative {
+	script := strings.ReplaceAll(postinst, "\\\n", " ")
+
+	var found []alternative
+	for _, line := range strings.Split(script, "\n") {
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			if filepath.Base(field) != "update-alternatives" {
+				continue
+			}
+			if candidate, ok := parseInstallArgs(fields[i+1:]); ok {
+				found = append(found, candidate)
+			}
+		}
+	}
+
+	return found
+}
+
+func parseInstallArgs(args []string) (alternative, bool) {
+	var words []string
+	for _, arg := range args {
+		if arg == ";" || arg == "&&" || arg == "||" || arg == "|" || strings.HasPrefix(arg, "#") {
+			break
+		}
+		word := strings.Trim(arg, `"'`)
+		if strings.HasSuffix(word, ";") {
+			words = append(words, strings.Trim(strings.TrimSuffix(word, ";"), `"'`))
+			break
+		}
+		words = append(words, word)
+	}
+
+	for len(words) > 0 && words[0] != "--install" {
+		if !strings.HasPrefix(words[0], "--") {
+			return alternative{}, false
+		}
+		words = words[1:]
+	}
+	if len(words) < 5 || !literal(words[1:4]...) {
+		return alternative{}, false
+	}
+
+	priority, err := strconv.Atoi(words[4])
+	if err != nil {
+		return alternative{}, false
+	}
+	candidate := alternative{Link: words[1], Name: words[2], Path: words[3], Priority: priority}
+
+	for rest := words[5:]; len(rest) >= 4 && rest[0] == "--slave"; rest = rest[4:] {
+		if literal(rest[1:4]...) {
+			candidate.Slaves = append(candidate.Slaves, alternativeSlave{Link: rest[1], Name: rest[2], Path: rest[3]})
+		}
+	}
+
+	return candidate, true
+}
+
+func literal(words ...string) bool {
+	for _, word := range words {
+		if strings.ContainsAny(word, "$`*") {
+			return false
+		}
+	}
+	return true
+}
+
+// parseJinfo reads the alternatives that Java runtimes list in their .jinfo
+// file, which their postinst registers in a loop.
+func parseJinfo(path string) ([]alternative, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	priority := 0
+	var found []alternative
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if value, ok := strings.CutPrefix(line, "priority="); ok {
+			priority, _ = strconv.Atoi(value)
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 3 && jinfoLinks[fields[0]] {
+			found = append(found, alternative{
+				Link: filepath.Join("/usr/bin", fields[1]),
+				Name: fields[1],
+				Path: fields[2],
+			})
+		}
+	}
+
+	for i := range found {
+		found[i].Priority = priority
+	}
+	return found, scanner.Err()
+}
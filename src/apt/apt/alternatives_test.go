@@ -0,0 +1,114 @@
+package apt_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Alternatives", func() {
+	var (
+		f            *aptFixture
+		a            *apt.Apt
+		alternatives map[string]string
+	)
+
+	vimPostinst := "#!/bin/sh\nset -e\n\n" +
+		"if [ \"$1\" = configure ]; then\n" +
+		"    update-alternatives --install /usr/bin/editor editor /usr/bin/vim.basic 30 \\\n" +
+		"        --slave /usr/share/man/man1/editor.1.gz editor.1.gz /usr/share/man/man1/vim.1.gz\n" +
+		"    update-alternatives --install /usr/bin/vi vi \"$BIN\" 30\n" +
+		"fi\n"
+
+	nanoPostinst := "#!/bin/sh\nupdate-alternatives --quiet --install /usr/bin/editor editor /bin/nano 40;\n"
+
+	jinfo := "name=java-17-openjdk-amd64\npriority=1711\nsection=main\n\n" +
+		"hl java /usr/lib/jvm/java-17-openjdk-amd64/bin/java\n" +
+		"jdkhl javac /usr/lib/jvm/java-17-openjdk-amd64/bin/javac\n" +
+		"jdk jconsole /usr/lib/jvm/java-17-openjdk-amd64/bin/jconsole\n"
+
+	readlink := func(path string) string {
+		target, err := filepath.EvalSymlinks(filepath.Join(f.installDir, path))
+		Expect(err).NotTo(HaveOccurred())
+		rel, err := filepath.Rel(f.installDir, target)
+		Expect(err).NotTo(HaveOccurred())
+		return "/" + rel
+	}
+
+	BeforeEach(func() {
+		f = newAptFixture()
+		alternatives = nil
+
+		writeDeb(filepath.Join(f.archiveDir, "vim_9.0_amd64.deb"), "Package: vim\n", "gz", []debEntry{
+			{Name: "./usr/bin/vim.basic", Body: "vim", Mode: 0755},
+			{Name: "./usr/share/man/man1/vim.1.gz", Body: "man"},
+		}, debEntry{Name: "./postinst", Body: vimPostinst, Mode: 0755})
+		writeDeb(filepath.Join(f.archiveDir, "nano_7.2_amd64.deb"), "Package: nano\n", "gz", []debEntry{
+			{Name: "./bin/nano", Body: "nano", Mode: 0755},
+		}, debEntry{Name: "./postinst", Body: nanoPostinst, Mode: 0755})
+		writeDeb(filepath.Join(f.archiveDir, "openjdk-17-jdk-headless_17_amd64.deb"), "Package: openjdk-17-jdk-headless\n", "gz", []debEntry{
+			{Name: "./usr/lib/jvm/.java-1.17.0-openjdk-amd64.jinfo", Body: jinfo},
+			{Name: "./usr/lib/jvm/java-17-openjdk-amd64/bin/java", Body: "java", Mode: 0755},
+			{Name: "./usr/lib/jvm/java-17-openjdk-amd64/bin/javac", Body: "javac", Mode: 0755},
+		})
+	})
+
+	JustBeforeEach(func() {
+		f.writeAptYml(&apt.Apt{
+			Packages:     []apt.Package{{Name: "vim"}, {Name: "nano"}, {Name: "openjdk-17-jdk-headless"}},
+			Alternatives: alternatives,
+		})
+
+		a = f.newApt()
+		Expect(a.Setup()).To(Succeed())
+		resolveArchives(f.mockCommand, a, "vim_9.0_amd64.deb", "nano_7.2_amd64.deb", "openjdk-17-jdk-headless_17_amd64.deb")
+	})
+
+	It("links the highest priority alternative through etc/alternatives", func() {
+		Expect(a.InstallAll()).To(Succeed())
+
+		Expect(os.Readlink(filepath.Join(f.installDir, "usr", "bin", "editor"))).To(Equal("../../etc/alternatives/editor"))
+		Expect(os.Readlink(filepath.Join(f.installDir, "etc", "alternatives", "editor"))).To(Equal("../../bin/nano"))
+		Expect(f.buffer.String()).To(ContainSubstring("Using /bin/nano for /usr/bin/editor (alternative editor)"))
+	})
+
+	It("links alternatives listed in the .jinfo of Java runtimes", func() {
+		Expect(a.InstallAll()).To(Succeed())
+
+		Expect(readlink("usr/bin/java")).To(Equal("/usr/lib/jvm/java-17-openjdk-amd64/bin/java"))
+		Expect(readlink("usr/bin/javac")).To(Equal("/usr/lib/jvm/java-17-openjdk-amd64/bin/javac"))
+		Expect(filepath.Join(f.installDir, "usr", "bin", "jconsole")).NotTo(BeAnExistingFile())
+	})
+
+	It("skips registrations that are not literal", func() {
+		Expect(a.InstallAll()).To(Succeed())
+		Expect(filepath.Join(f.installDir, "usr", "bin", "vi")).NotTo(BeAnExistingFile())
+	})
+
+	Context("when apt.yml chooses an alternative", func() {
+		BeforeEach(func() {
+			alternatives = map[string]string{"editor": "/usr/bin/vim.basic"}
+		})
+
+		It("links the chosen alternative and its slaves", func() {
+			Expect(a.InstallAll()).To(Succeed())
+
+			Expect(readlink("usr/bin/editor")).To(Equal("/usr/bin/vim.basic"))
+			Expect(readlink("usr/share/man/man1/editor.1.gz")).To(Equal("/usr/share/man/man1/vim.1.gz"))
+		})
+	})
+
+	Context("when apt.yml chooses an alternative that is not installed", func() {
+		BeforeEach(func() {
+			alternatives = map[string]string{"editor": "/usr/bin/emacs"}
+		})
+
+		It("fails listing the installed candidates", func() {
+			Expect(a.InstallAll()).To(MatchError("alternative editor is set to /usr/bin/emacs in apt.yml, but the installed candidates are: /usr/bin/vim.basic, /bin/nano"))
+		})
+	})
+})
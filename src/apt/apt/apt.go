@@ -1,16 +1,17 @@
 package apt
 
 import (
-	"bytes"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
 	"github.com/cloudfoundry/libbuildpack"
+	"golang.org/x/sync/errgroup"
 )
 
 type Command interface {
@@ -21,6 +22,7 @@ type Command interface {
 type Repository struct {
 	Name     string
 	Priority string
+	Key      Key
 }
 
 func (r *Repository) UnmarshalYAML(unmarshal func(interface{}) error) error {
@@ -33,6 +35,7 @@ func (r *Repository) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	data := struct {
 		Name     string
 		Priority string
+		Key      Key
 	}{}
 	err := unmarshal(&data)
 	if err != nil {
@@ -41,61 +44,130 @@ func (r *Repository) UnmarshalYAML(unmarshal func(interface{}) error) error {
 
 	r.Name = data.Name
 	r.Priority = data.Priority
+	r.Key = data.Key
 	return nil
 }
 
+// Package is an entry of packages in apt.yml, either a package name for the
+// configured repos or the URL of a .deb. SHA256 is only checked for URLs.
+type Package struct {
+	Name   string `yaml:"name"`
+	SHA256 string `yaml:"sha256,omitempty"`
+}
+
+func (p *Package) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		p.Name = name
+		return nil
+	}
+
+	data := struct {
+		Name   string
+		SHA256 string
+	}{}
+	err := unmarshal(&data)
+	if err != nil {
+		return err
+	}
+
+	p.Name = data.Name
+	p.SHA256 = data.SHA256
+	return nil
+}
+
+func (p Package) IsURL() bool {
+	return strings.HasSuffix(p.Name, ".deb")
+}
+
 type Apt struct {
-	command            Command
-	options            []string
-	aptFilePath        string
-	TruncateSources    bool         `yaml:"truncatesources,omitempty"`
-	CleanCache         bool         `yaml:"cleancache,omitempty"`
-	Keys               []string     `yaml:"keys"`
-	GpgAdvancedOptions []string     `yaml:"gpg_advanced_options"`
-	Repos              []Repository `yaml:"repos"`
-	Packages           []string     `yaml:"packages"`
-	rootDir            string
-	cacheDir           string
-	stateDir           string
-	sourceList         string
-	trustedKeys        string
-	installDir         string
-	preferences        string
-	archiveDir         string
-	logger             *libbuildpack.Logger
+	command                Command
+	options                []string
+	aptFilePath            string
+	TruncateSources        bool              `yaml:"truncatesources,omitempty"`
+	CleanCache             bool              `yaml:"cleancache,omitempty"`
+	Keys                   []Key             `yaml:"keys"`
+	GpgAdvancedOptions     []string          `yaml:"gpg_advanced_options"`
+	Repos                  []Repository      `yaml:"repos"`
+	Packages               []Package         `yaml:"packages"`
+	BuildPackages          []Package         `yaml:"build_packages,omitempty"`
+	ReinstallStackPackages bool              `yaml:"reinstall_stack_packages,omitempty"`
+	LicenseDenylist        []string          `yaml:"license_denylist,omitempty"`
+	Alternatives           map[string]string `yaml:"alternatives,omitempty"`
+	AddedArchitectures     []string          `yaml:"architectures,omitempty"`
+	RuntimeEnv             map[string]bool   `yaml:"runtime_env,omitempty"`
+	EnvVars                map[string]string `yaml:"env,omitempty"`
+	LinkDirs               map[string]string `yaml:"links,omitempty"`
+	ExcludePaths           []string          `yaml:"exclude_paths,omitempty"`
+	IncludePaths           []string          `yaml:"include_paths,omitempty"`
+	MaxSize                string            `yaml:"max_size,omitempty"`
+	UpdatePolicy           string            `yaml:"update,omitempty"`
+	rootDir                string
+	cacheDir               string
+	stateDir               string
+	sourceList             string
+	trustedKeys            string
+	trustedParts           string
+	keyrings               string
+	installDir             string
+	buildInstallDir        string
+	preferences            string
+	archiveDir             string
+	lockFilePath           string
+	lock                   *Lockfile
+	resolved               []LockedPackage
+	resolvedBuild          []LockedPackage
+	architectures          []string
+	pathFilter             *pathFilter
+	installedSizes         []packageSize
+	logger                 *libbuildpack.Logger
 }
 
 func New(command Command, aptFile, rootDir, cacheDir, installDir string, logger *libbuildpack.Logger) *Apt {
 	sourceList := filepath.Join(cacheDir, "apt", "sources", "sources.list")
 	trustedKeys := filepath.Join(cacheDir, "apt", "etc", "trusted.gpg")
+	trustedParts := filepath.Join(cacheDir, "apt", "etc", "trusted.gpg.d")
 	preferences := filepath.Join(cacheDir, "apt", "etc", "preferences")
 	aptCacheDir := filepath.Join(cacheDir, "apt", "cache")
 	stateDir := filepath.Join(cacheDir, "apt", "state")
 
 	return &Apt{
-		command:     command,
-		aptFilePath: aptFile,
-		rootDir:     rootDir,
-		cacheDir:    aptCacheDir,
-		stateDir:    stateDir,
-		sourceList:  sourceList,
-		trustedKeys: trustedKeys,
-		preferences: preferences,
+		command:      command,
+		aptFilePath:  aptFile,
+		rootDir:      rootDir,
+		cacheDir:     aptCacheDir,
+		stateDir:     stateDir,
+		sourceList:   sourceList,
+		trustedKeys:  trustedKeys,
+		trustedParts: trustedParts,
+		keyrings:     filepath.Join(cacheDir, "apt", "etc", "keyrings"),
+		preferences:  preferences,
 		options: []string{
 			"-o", "debug::nolocking=true",
 			"-o", "dir::cache=" + aptCacheDir,
 			"-o", "dir::state=" + stateDir,
 			"-o", "dir::etc::sourcelist=" + sourceList,
 			"-o", "dir::etc::trusted=" + trustedKeys,
+			"-o", "dir::etc::trustedparts=" + trustedParts,
 			"-o", "Dir::Etc::preferences=" + preferences,
 		},
-		installDir: installDir,
-		archiveDir: filepath.Join(aptCacheDir, "archives"),
-		logger:     logger,
+		installDir:      installDir,
+		buildInstallDir: filepath.Join(cacheDir, "apt", "build"),
+		archiveDir:      filepath.Join(aptCacheDir, "archives"),
+		lockFilePath:    filepath.Join(filepath.Dir(aptFile), "apt.lock"),
+		logger:          logger,
 	}
 }
 
 func (a *Apt) Setup() error {
+	if err := libbuildpack.NewYAML().Load(a.aptFilePath, a); err != nil {
+		return err
+	}
+
+	if err := a.invalidateCache(); err != nil {
+		return err
+	}
+
 	if err := os.MkdirAll(a.cacheDir, os.ModePerm); err != nil {
 		return err
 	}
@@ -122,6 +194,19 @@ func (a *Apt) Setup() error {
 		}
 	}
 
+	if err := os.MkdirAll(a.trustedParts, 0755); err != nil {
+		return err
+	}
+
+	aptGPGParts := filepath.Join(a.rootDir, "trusted.gpg.d")
+	if exists, err := libbuildpack.FileExists(aptGPGParts); err != nil {
+		return err
+	} else if exists {
+		if err := libbuildpack.CopyDirectory(aptGPGParts, a.trustedParts); err != nil {
+			return err
+		}
+	}
+
 	aptPrefs := filepath.Join(a.rootDir, "preferences")
 	if exists, err := libbuildpack.FileExists(aptPrefs); err != nil {
 		return err
@@ -137,7 +222,28 @@ func (a *Apt) Setup() error {
 		}
 	}
 
-	return libbuildpack.NewYAML().Load(a.aptFilePath, a)
+	if err := a.configureArchitectures(); err != nil {
+		return err
+	}
+
+	filter, err := newPathFilter(a.ExcludePaths, a.IncludePaths)
+	if err != nil {
+		return fmt.Errorf("invalid paths in apt.yml\n\n%s", err)
+	}
+	if filter == nil && len(a.IncludePaths) > 0 {
+		a.logger.Warning("include_paths in apt.yml has no effect without exclude_paths, installing every file")
+	}
+	a.pathFilter = filter
+
+	if _, err := parseSize(a.MaxSize); err != nil {
+		return err
+	}
+
+	if _, err := a.updateMaxAge(); err != nil {
+		return err
+	}
+
+	return a.loadLock()
 }
 
 func (a *Apt) HasKeys() bool {
@@ -148,24 +254,6 @@ func (a *Apt) HasRepos() bool {
 	return len(a.Repos) > 0
 }
 
-func (a *Apt) AddKeys() error {
-	for _, options := range a.GpgAdvancedOptions {
-		if out, err := a.command.Output("/", "apt-key", "--keyring", a.trustedKeys, "adv", options); err != nil {
-			a.logger.Info("%s", out)
-			return fmt.Errorf("could not pass gpg advanced options %s\n\n%s\n\n%s", options, out, err)
-		}
-	}
-
-	for _, keyURL := range a.Keys {
-		if out, err := a.command.Output("/", "apt-key", "--keyring", a.trustedKeys, "adv", "--fetch-keys", keyURL); err != nil {
-			a.logger.Info("%s", out)
-			return fmt.Errorf("could not add apt key %s\n\n%s\n\n%s", keyURL, out, err)
-		}
-	}
-
-	return nil
-}
-
 func (a *Apt) AddRepos() error {
 	openmode := os.O_APPEND
 
@@ -181,7 +269,16 @@ func (a *Apt) AddRepos() error {
 	defer f.Close()
 
 	for _, repo := range a.Repos {
-		if _, err = f.WriteString("\n" + repo.Name); err != nil {
+		line := repo.Name
+		if repo.Key.URL != "" {
+			keyring, err := a.repoKeyring(repo)
+			if err != nil {
+				return err
+			}
+			line = withSignedBy(line, keyring)
+		}
+
+		if _, err = f.WriteString("\n" + line); err != nil {
 			return err
 		}
 	}
@@ -203,10 +300,48 @@ func (a *Apt) AddRepos() error {
 	return nil
 }
 
+// withSignedBy adds a signed-by option to a one-line source entry, so that
+// the keyring is only trusted for that entry.
+func withSignedBy(line, keyring string) string {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return line
+	}
+
+	if strings.HasPrefix(fields[1], "[") {
+		options := strings.Join(fields[1:], " ")
+		end := strings.Index(options, "]")
+		if end < 0 {
+			return line
+		}
+		return fields[0] + " " + strings.TrimSpace(options[:end]) + " signed-by=" + keyring + options[end:]
+	}
+
+	return fields[0] + " [signed-by=" + keyring + "] " + strings.Join(fields[1:], " ")
+}
+
 func (a *Apt) HasClean() bool {
 	return a.CleanCache
 }
 
+// EnvSwitches returns the runtime_env entries of apt.yml, which switch the
+// export of search path variables such as PYTHONPATH on or off.
+func (a *Apt) EnvSwitches() map[string]bool {
+	return a.RuntimeEnv
+}
+
+// Env returns the env section of apt.yml, variables whose values may refer
+// to the install root as ${APT_ROOT}.
+func (a *Apt) Env() map[string]string {
+	return a.EnvVars
+}
+
+// Links returns the links section of apt.yml, which maps dirs of the install
+// root to the dep dir they are linked into, such as bin or lib.
+func (a *Apt) Links() map[string]string {
+	return a.LinkDirs
+}
+
 func (a *Apt) Clean() error {
 	fmt.Printf("Cleaning apt cache \n")
 	args := append(a.options, "clean")
@@ -221,74 +356,244 @@ func (a *Apt) Clean() error {
 	return nil
 }
 
-func (a *Apt) Update() error {
-	args := append(a.options, "update")
+// DownloadAll resolves and downloads the packages and build_packages of
+// apt.yml. Build packages that are also resolved as packages are only
+// installed once, into the droplet.
+func (a *Apt) DownloadAll() error {
+	if a.lock != nil {
+		a.logger.Info("Using package versions pinned in apt.lock")
+		return a.downloadLocked()
+	}
+
+	resolved, err := a.downloadPackages(a.Packages)
+	if err != nil {
+		return err
+	}
+	a.resolved = resolved
+
+	a.resolvedBuild = nil
+	if len(a.BuildPackages) > 0 {
+		build, err := a.downloadPackages(a.BuildPackages)
+		if err != nil {
+			return err
+		}
 
-	var errBuff bytes.Buffer
-	if err := a.command.Execute("/", &errBuff, &errBuff, "apt-get", args...); err != nil {
-		return fmt.Errorf("failed to apt-get update %s\n\n%s", errBuff.String(), err)
+		inDroplet := map[string]bool{}
+		for _, pkg := range resolved {
+			inDroplet[pkg.file] = true
+		}
+		for _, pkg := range build {
+			if !inDroplet[pkg.file] {
+				pkg.Build = true
+				a.resolvedBuild = append(a.resolvedBuild, pkg)
+			}
+		}
 	}
+
 	return nil
 }
 
-func (a *Apt) DownloadAll() error {
-	debPackages, repoPackages := make([]string, 0), make([]string, 0)
+func (a *Apt) downloadPackages(packages []Package) ([]LockedPackage, error) {
+	debPackages, repoPackages := make([]Package, 0), make([]string, 0)
 
-	for _, pkg := range a.Packages {
-		if strings.HasSuffix(pkg, ".deb") {
+	for _, pkg := range packages {
+		if pkg.IsURL() {
 			debPackages = append(debPackages, pkg)
-		} else if pkg != "" {
-			repoPackages = append(repoPackages, pkg)
+		} else if pkg.Name != "" {
+			repoPackages = append(repoPackages, pkg.Name)
 		}
 	}
 
+	resolved := make([]LockedPackage, 0)
+
+	// downloaded .debs are passed to apt by path, so their dependencies are
+	// resolved from the configured repos like those of any other package
+	aptPackages := make([]string, 0)
+
 	for _, pkg := range debPackages {
 		err := a.download(pkg)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		file := filepath.Join(a.archiveDir, filepath.Base(pkg.Name))
+		fields, err := a.debFields(file)
+		if err != nil {
+			return nil, err
 		}
+
+		resolved = append(resolved, LockedPackage{
+			Name:         fields["Package"],
+			Version:      fields["Version"],
+			Architecture: fields["Architecture"],
+			Source:       pkg.Name,
+			file:         file,
+		})
+		aptPackages = append(aptPackages, file)
 	}
+	aptPackages = append(aptPackages, repoPackages...)
 
-	// download all repo packages in one invocation
-	aptArgs := append(a.options, "-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "-d", "install", "--reinstall")
-	args := append(aptArgs, repoPackages...)
+	if len(aptPackages) > 0 {
+		repoResolved, err := a.resolve(aptPackages)
+		if err != nil {
+			return nil, err
+		}
+		resolved = append(resolved, repoResolved...)
+	}
+
+	// download all repo packages and dependencies in one invocation
+	aptArgs := append(a.options, "-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages")
+	args := append(append(aptArgs, a.installArgs()...), aptPackages...)
 	out, err := a.command.Output("/", "apt-get", args...)
 	a.logger.Info("%s", out)
 	if err != nil {
-		return fmt.Errorf("failed apt-get install %s\n\n%s", out, err)
+		return nil, fmt.Errorf("failed apt-get install %s\n\n%s", out, err)
 	}
 
-	return nil
+	for i := range resolved {
+		if resolved[i].SHA256, err = fileSHA256(resolved[i].file); err != nil {
+			return nil, err
+		}
+	}
+
+	return resolved, nil
 }
 
+// installArgs lets apt skip packages the stack already has installed, as
+// recorded in its dpkg status database, unless reinstall_stack_packages is
+// set. Skipped packages are neither downloaded nor extracted, so the stack's
+// copies stay first on the library path.
+func (a *Apt) installArgs() []string {
+	if a.ReinstallStackPackages {
+		return []string{"-d", "install", "--reinstall"}
+	}
+	return []string{"-d", "install"}
+}
+
+// InstallAll extracts the packages resolved by DownloadAll in parallel,
+// and the build packages into BuildPackagesDir. Where packages share a path,
+// the last of them in resolved order wins. Archives left in the cache by
+// earlier stagings are removed instead of being installed.
 func (a *Apt) InstallAll() error {
+	if err := a.pruneArchives(); err != nil {
+		return err
+	}
+
+	installed, err := a.installPackages(a.resolved, a.installDir, a.pathFilter)
+	if err != nil {
+		return err
+	}
+
+	a.recordSizes(a.resolved, installed)
+
+	if excluded := excludedFiles(installed); excluded > 0 {
+		a.logger.Info("Left out %d files matching exclude_paths in apt.yml", excluded)
+	}
+
+	if err := a.writeStatus(installed); err != nil {
+		return err
+	}
+
+	if err := a.installAlternatives(installed); err != nil {
+		return err
+	}
+
+	return a.installBuildPackages()
+}
+
+func (a *Apt) installPackages(packages []LockedPackage, dir string, filter *pathFilter) ([]*debContents, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var group errgroup.Group
+	group.SetLimit(runtime.NumCPU())
+
+	tree := newInstallTree(dir)
+	installed := make([]*debContents, len(packages))
+	for i, pkg := range packages {
+		file := pkg.file
+		group.Go(func() error {
+			contents, err := a.install(file, tree, i, filter)
+			installed[i] = contents
+			return err
+		})
+	}
+
+	return installed, group.Wait()
+}
+
+func (a *Apt) pruneArchives() error {
 	files, err := filepath.Glob(filepath.Join(a.archiveDir, "*.deb"))
 	if err != nil {
 		return err
 	}
 
+	resolved := map[string]bool{}
+	for _, packages := range [][]LockedPackage{a.resolved, a.resolvedBuild} {
+		for _, pkg := range packages {
+			resolved[pkg.file] = true
+		}
+	}
+
 	for _, file := range files {
-		err := a.install(filepath.Base(file))
-		if err != nil {
+		if resolved[file] {
+			continue
+		}
+
+		a.logger.Info("Removing %s from the apt cache, it is not needed by this staging", filepath.Base(file))
+		if err := os.Remove(file); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (a *Apt) install(pkg string) error {
-	output, err := a.command.Output("/", "dpkg", "-x", filepath.Join(a.archiveDir, pkg), a.installDir)
-	a.logger.Info("%s", output)
+func (a *Apt) install(file string, tree *installTree, order int, filter *pathFilter) (*debContents, error) {
+	contents, err := extractDeb(file, tree, order, filter)
 	if err != nil {
-		return fmt.Errorf("failed to install pkg %s\n\n%s\n\n%s", pkg, output, err.Error())
+		return nil, fmt.Errorf("failed to install pkg %s\n\n%s", filepath.Base(file), err)
+	}
+	return contents, nil
+}
+
+// download fetches a .deb given by URL into the archives dir. When a sha256
+// is given, a cached archive is only reused if it still matches, and a fresh
+// download is removed again if it does not.
+func (a *Apt) download(pkg Package) error {
+	downloadedPkg := filepath.Join(a.archiveDir, filepath.Base(pkg.Name))
+
+	if pkg.SHA256 != "" {
+		if exists, err := libbuildpack.FileExists(downloadedPkg); err != nil {
+			return err
+		} else if exists {
+			if err := verifySHA256(downloadedPkg, pkg.SHA256); err == nil {
+				return nil
+			}
+			a.logger.Info("Cached %s does not match its sha256, downloading it again", filepath.Base(pkg.Name))
+			if err := os.Remove(downloadedPkg); err != nil {
+				return err
+			}
+		}
 	}
+
+	if err := a.fetch(pkg.Name, downloadedPkg); err != nil {
+		return err
+	}
+
+	if pkg.SHA256 != "" {
+		if err := verifySHA256(downloadedPkg, pkg.SHA256); err != nil {
+			os.Remove(downloadedPkg)
+			return fmt.Errorf("could not verify pkg %s: %s", pkg.Name, err)
+		}
+	}
+
 	return nil
 }
 
-func (a *Apt) download(pkg string) error {
+func (a *Apt) fetch(pkg, downloadedPkg string) error {
 	var lastModLocal time.Time
 
-	downloadedPkg := filepath.Join(a.archiveDir, filepath.Base(pkg))
 	exists, err := libbuildpack.FileExists(downloadedPkg)
 	if err != nil {
 		return err
@@ -328,6 +633,9 @@ func (a *Apt) download(pkg string) error {
 
 	diff := lastModRemote.Sub(lastModLocal)
 	if diff >= 0 {
+		if err := packageFile.Truncate(0); err != nil {
+			return err
+		}
 		if n, err := io.Copy(packageFile, resp.Body); err != nil {
 			return err
 		} else if n < resp.ContentLength {
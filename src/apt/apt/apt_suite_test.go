@@ -1,13 +1,76 @@
 package apt_test
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-
-	"testing"
 )
 
 func TestApt(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Apt Suite")
 }
+
+// aptFixture is what a test of apt stages with: an app dir for apt.yml, the
+// root filesystem of the stack with an empty /etc/apt/sources.list, and a
+// cache dir, all removed after the test.
+type aptFixture struct {
+	appDir      string
+	stackDir    string
+	rootDir     string
+	cacheDir    string
+	installDir  string
+	archiveDir  string
+	mockCommand *MockCommand
+	buffer      *bytes.Buffer
+}
+
+func newAptFixture() *aptFixture {
+	stackDir, cacheDir := tempDir("stack"), tempDir("cachedir")
+	f := &aptFixture{
+		appDir:      tempDir("appdir"),
+		stackDir:    stackDir,
+		rootDir:     filepath.Join(stackDir, "etc", "apt"),
+		cacheDir:    cacheDir,
+		installDir:  filepath.Join(cacheDir, "install"),
+		archiveDir:  filepath.Join(cacheDir, "apt", "cache", "archives"),
+		mockCommand: NewMockCommand(gomock.NewController(GinkgoT())),
+		buffer:      new(bytes.Buffer),
+	}
+	Expect(os.MkdirAll(f.rootDir, 0755)).To(Succeed())
+	f.writeSourcesList("")
+	return f
+}
+
+// tempDir creates a dir that is removed after the test.
+func tempDir(pattern string) string {
+	dir, err := os.MkdirTemp("", pattern)
+	Expect(err).NotTo(HaveOccurred())
+	DeferCleanup(os.RemoveAll, dir)
+	return dir
+}
+
+func (f *aptFixture) aptFile() string {
+	return filepath.Join(f.appDir, "apt.yml")
+}
+
+func (f *aptFixture) writeAptYml(aptYml *apt.Apt) {
+	Expect(libbuildpack.NewYAML().Write(f.aptFile(), aptYml)).To(Succeed())
+}
+
+func (f *aptFixture) writeSourcesList(sources string) {
+	Expect(os.WriteFile(filepath.Join(f.rootDir, "sources.list"), []byte(sources), 0666)).To(Succeed())
+}
+
+// newApt creates apt for the apt.yml of the app, logging to the buffer.
+func (f *aptFixture) newApt() *apt.Apt {
+	return apt.New(f.mockCommand, f.aptFile(), f.rootDir, f.cacheDir, f.installDir, libbuildpack.NewLogger(f.buffer))
+}
@@ -2,6 +2,10 @@ package apt_test
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 
@@ -62,12 +66,15 @@ var _ = Describe("Apt", func() {
 		JustBeforeEach(func() {
 			content := &apt.Apt{
 				GpgAdvancedOptions: []string{"--keyserver keys.gnupg.net --recv-keys 09617FD37CC06B54"},
-				Keys:               []string{"https://example.com/public.key"},
+				Keys:               []apt.Key{{URL: "https://example.com/public.key"}},
 				Repos: []apt.Repository{
 					apt.Repository{Name: "deb http://apt.example.com stable main"},
 					apt.Repository{Name: "foo bar baz", Priority: "100"},
+					apt.Repository{Name: "deb http://signed.example.com stable main", Key: apt.Key{URL: "https://signed.example.com/public.key", Fingerprints: []string{"0123456789ABCDEF0123456789ABCDEF01234567"}}},
 				},
-				Packages: []string{"abc", "def"},
+				Packages: []apt.Package{{Name: "abc"}, {Name: "def"}},
+				EnvVars:  map[string]string{"JAVA_HOME": "${APT_ROOT}/usr/lib/jvm/java-17-openjdk-amd64"},
+				LinkDirs: map[string]string{"opt/vendor/bin": "bin"},
 			}
 			Expect(libbuildpack.NewYAML().Write(aptFile, content)).To(Succeed())
 
@@ -75,7 +82,7 @@ var _ = Describe("Apt", func() {
 		})
 
 		It("sets keys from apt.yml", func() {
-			Expect(a.Keys).To(Equal([]string{"https://example.com/public.key"}))
+			Expect(a.Keys).To(Equal([]apt.Key{{URL: "https://example.com/public.key"}}))
 		})
 
 		It("sets gpg advanced options from apt.yml", func() {
@@ -86,11 +93,17 @@ var _ = Describe("Apt", func() {
 			Expect(a.Repos).To(Equal([]apt.Repository{
 				apt.Repository{Name: "deb http://apt.example.com stable main"},
 				apt.Repository{Name: "foo bar baz", Priority: "100"},
+				apt.Repository{Name: "deb http://signed.example.com stable main", Key: apt.Key{URL: "https://signed.example.com/public.key", Fingerprints: []string{"0123456789ABCDEF0123456789ABCDEF01234567"}}},
 			}))
 		})
 
 		It("sets packages from apt.yml", func() {
-			Expect(a.Packages).To(Equal([]string{"abc", "def"}))
+			Expect(a.Packages).To(Equal([]apt.Package{{Name: "abc"}, {Name: "def"}}))
+		})
+
+		It("sets env and links from apt.yml", func() {
+			Expect(a.Env()).To(Equal(map[string]string{"JAVA_HOME": "${APT_ROOT}/usr/lib/jvm/java-17-openjdk-amd64"}))
+			Expect(a.Links()).To(Equal(map[string]string{"opt/vendor/bin": "bin"}))
 		})
 
 		It("copies sources.list", func() {
@@ -123,7 +136,7 @@ var _ = Describe("Apt", func() {
 
 		Context("Keys have been specified", func() {
 			JustBeforeEach(func() {
-				a.Keys = []string{"https://example.com/public.key"}
+				a.Keys = []apt.Key{{URL: "https://example.com/public.key"}}
 			})
 
 			It("returns true from HasKeys()", func() {
@@ -138,55 +151,6 @@ var _ = Describe("Apt", func() {
 		})
 	})
 
-	Describe("AddKeys", func() {
-		Context("GPG Advanced Options have been specified", func() {
-			JustBeforeEach(func() {
-				a.GpgAdvancedOptions = []string{"--keyserver keys.gnupg.net --recv-keys 09617FD37CC06B54"}
-			})
-
-			It("adds the keys to the apt trusted keys", func() {
-				mockCommand.EXPECT().Output(
-					"/", "apt-key",
-					"--keyring", filepath.Join(cacheDir, "apt", "etc", "trusted.gpg"),
-					"adv",
-					"--keyserver keys.gnupg.net --recv-keys 09617FD37CC06B54",
-				).Return("Shell output", nil)
-
-				err := a.AddKeys()
-				Expect(err).ToNot(HaveOccurred())
-			})
-		})
-
-		Context("Keys have been specified", func() {
-			JustBeforeEach(func() {
-				a.Keys = []string{"https://example.com/public.key"}
-			})
-
-			It("adds the keys to the apt trusted keys", func() {
-				mockCommand.EXPECT().Output(
-					"/", "apt-key",
-					"--keyring", filepath.Join(cacheDir, "apt", "etc", "trusted.gpg"),
-					"adv",
-					"--fetch-keys", "https://example.com/public.key",
-				).Return("Shell output", nil)
-
-				err := a.AddKeys()
-				Expect(err).ToNot(HaveOccurred())
-			})
-		})
-
-		Context("No keys specified", func() {
-			JustBeforeEach(func() {
-				a.Keys = []string{}
-			})
-
-			It("does nothing", func() {
-				err := a.AddKeys()
-				Expect(err).ToNot(HaveOccurred())
-			})
-		})
-	})
-
 	Describe("AddRepos", func() {
 		Context("Keys and priorities have been specified", func() {
 			var sourceList, prefFile string
@@ -226,7 +190,7 @@ var _ = Describe("Apt", func() {
 
 		Context("No keys specified", func() {
 			JustBeforeEach(func() {
-				a.Keys = []string{}
+				a.Keys = []apt.Key{}
 			})
 
 			It("does nothing", func() {
@@ -245,6 +209,7 @@ var _ = Describe("Apt", func() {
 				"-o", "dir::state="+cacheDir+"/apt/state",
 				"-o", "dir::etc::sourcelist="+cacheDir+"/apt/sources/sources.list",
 				"-o", "dir::etc::trusted="+cacheDir+"/apt/etc/trusted.gpg",
+				"-o", "dir::etc::trustedparts="+cacheDir+"/apt/etc/trusted.gpg.d",
 				"-o", "Dir::Etc::preferences="+cacheDir+"/apt/etc/preferences",
 				"update",
 			).Return(nil)
@@ -264,47 +229,63 @@ var _ = Describe("Apt", func() {
 
 		JustBeforeEach(func() {
 			fooServer = ghttp.NewServer()
-			fooServer.AppendHandlers(
+			fooServer.AppendHandlers(ghttp.CombineHandlers(
 				ghttp.VerifyRequest("GET", "/"+fooFileName),
-			)
+				ghttp.RespondWith(http.StatusOK, debArchive("Package: foo\nVersion: 1.0\nArchitecture: amd64\n", "gz", nil)),
+			))
 			fooFileUri := fooServer.URL() + "/" + fooFileName
 
 			barServer = ghttp.NewServer()
-			barServer.AppendHandlers(
+			barServer.AppendHandlers(ghttp.CombineHandlers(
 				ghttp.VerifyRequest("GET", "/"+barFileName),
-			)
+				ghttp.RespondWith(http.StatusOK, debArchive("Package: bar\nVersion: 2.0\nArchitecture: all\n", "gz", nil)),
+			))
 			barFileUri := barServer.URL() + "/" + barFileName
 
 			content := &apt.Apt{
 				GpgAdvancedOptions: []string{"--keyserver keys.gnupg.net --recv-keys 09617FD37CC06B54"},
-				Keys:               []string{"https://example.com/public.key"},
+				Keys:               []apt.Key{{URL: "https://example.com/public.key"}},
 				Repos: []apt.Repository{
 					apt.Repository{Name: "deb http://apt.example.com stable main"},
 					apt.Repository{Name: "foo bar baz", Priority: "100"},
 				},
-				Packages: []string{"abc", "def"},
+				Packages: []apt.Package{{Name: "abc"}, {Name: "def"}},
 			}
 			Expect(libbuildpack.NewYAML().Write(aptFile, content)).To(Succeed())
 
 			Expect(a.Setup()).To(Succeed())
 
-			a.Packages = []string{fooFileUri, barFileUri}
+			a.Packages = []apt.Package{{Name: fooFileUri}, {Name: barFileUri}}
 			DeferCleanup(func() {
 				fooServer.Close()
 				barServer.Close()
 			})
 		})
 
-		It("downloads user specified packages using http get's", func() {
-			mockCommand.EXPECT().Output(
+		expectAptGet := func(args ...interface{}) *gomock.Call {
+			return mockCommand.EXPECT().Output(
 				"/", "apt-get",
-				"-o", "debug::nolocking=true",
-				"-o", "dir::cache="+cacheDir+"/apt/cache",
-				"-o", "dir::state="+cacheDir+"/apt/state",
-				"-o", "dir::etc::sourcelist="+cacheDir+"/apt/sources/sources.list",
-				"-o", "dir::etc::trusted="+cacheDir+"/apt/etc/trusted.gpg",
-				"-o", "Dir::Etc::preferences="+cacheDir+"/apt/etc/preferences",
-				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "-d", "install", "--reinstall",
+				append([]interface{}{
+					"-o", "debug::nolocking=true",
+					"-o", "dir::cache=" + cacheDir + "/apt/cache",
+					"-o", "dir::state=" + cacheDir + "/apt/state",
+					"-o", "dir::etc::sourcelist=" + cacheDir + "/apt/sources/sources.list",
+					"-o", "dir::etc::trusted=" + cacheDir + "/apt/etc/trusted.gpg",
+					"-o", "dir::etc::trustedparts=" + cacheDir + "/apt/etc/trusted.gpg.d",
+					"-o", "Dir::Etc::preferences=" + cacheDir + "/apt/etc/preferences",
+				}, args...)...,
+			)
+		}
+
+		It("downloads user specified packages using http get's", func() {
+			expectAptGet(
+				"-o", gomock.Any(),
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "--print-uris", "-d", "install",
+				filepath.Join(cacheDir, "apt", "cache", "archives", fooFileName), filepath.Join(cacheDir, "apt", "cache", "archives", barFileName),
+			).Return("", nil)
+			expectAptGet(
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "-d", "install",
+				filepath.Join(cacheDir, "apt", "cache", "archives", fooFileName), filepath.Join(cacheDir, "apt", "cache", "archives", barFileName),
 			).Return("apt output", nil)
 
 			Expect(a.DownloadAll()).To(Succeed())
@@ -312,22 +293,159 @@ var _ = Describe("Apt", func() {
 			Expect(barServer.ReceivedRequests()).Should(HaveLen(1))
 		})
 
+		It("reinstalls packages the stack already provides when configured to", func() {
+			a.ReinstallStackPackages = true
+			expectAptGet(
+				"-o", gomock.Any(),
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "--print-uris", "-d", "install", "--reinstall",
+				filepath.Join(cacheDir, "apt", "cache", "archives", fooFileName), filepath.Join(cacheDir, "apt", "cache", "archives", barFileName),
+			).Return("", nil)
+			expectAptGet(
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "-d", "install", "--reinstall",
+				filepath.Join(cacheDir, "apt", "cache", "archives", fooFileName), filepath.Join(cacheDir, "apt", "cache", "archives", barFileName),
+			).Return("apt output", nil)
+
+			Expect(a.DownloadAll()).To(Succeed())
+		})
+
+		It("downloads the dependencies of user specified packages from the configured repos", func() {
+			expectAptGet(
+				"-o", gomock.Any(),
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "--print-uris", "-d", "install",
+				filepath.Join(cacheDir, "apt", "cache", "archives", fooFileName), filepath.Join(cacheDir, "apt", "cache", "archives", barFileName),
+			).Return("'file:"+filepath.Join(cacheDir, "apt", "cache", "archives", fooFileName)+"' foo_1.0_amd64.deb 0 SHA256:aaaa\n"+
+				"'http://apt.example.com/pool/libfoo_3.0_amd64.deb' libfoo_3.0_amd64.deb 10 SHA256:bbbb\n", nil)
+			expectAptGet(
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "-d", "install",
+				filepath.Join(cacheDir, "apt", "cache", "archives", fooFileName), filepath.Join(cacheDir, "apt", "cache", "archives", barFileName),
+			).DoAndReturn(func(string, string, ...string) (string, error) {
+				return "apt output", os.WriteFile(filepath.Join(cacheDir, "apt", "cache", "archives", "libfoo_3.0_amd64.deb"), []byte("libfoo"), 0644)
+			})
+
+			Expect(a.DownloadAll()).To(Succeed())
+
+			lockPath := filepath.Join(cacheDir, "apt.lock")
+			Expect(a.WriteLock(lockPath)).To(Succeed())
+			lock := apt.Lockfile{}
+			Expect(libbuildpack.NewYAML().Load(lockPath, &lock)).To(Succeed())
+			Expect(lock.Packages).To(HaveLen(3))
+			Expect(lock.Packages[2].Name).To(Equal("libfoo"))
+		})
+
+		It("fails listing the unmet dependencies of user specified packages", func() {
+			expectAptGet(
+				"-o", gomock.Any(),
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "--print-uris", "-d", "install",
+				filepath.Join(cacheDir, "apt", "cache", "archives", fooFileName), filepath.Join(cacheDir, "apt", "cache", "archives", barFileName),
+			).Return("Some packages could not be installed.\n"+
+				"The following packages have unmet dependencies:\n"+
+				" foo : Depends: libfoo (>= 3.0) but it is not installable\n"+
+				"       Depends: libbar but it is not installable\n"+
+				"E: Unable to correct problems, you have held broken packages.\n", errors.New("exit status 100"))
+
+			err := a.DownloadAll()
+			Expect(err).To(MatchError(ContainSubstring("unmet dependencies:\nfoo : Depends: libfoo (>= 3.0) but it is not installable\nDepends: libbar but it is not installable\n")))
+		})
+
+	})
+
+	Describe("DownloadAll with a sha256 for a .deb URL", func() {
+		var (
+			server     *ghttp.Server
+			debURL     string
+			archiveDir string
+			deb        = debArchive("Package: foo\nVersion: 1.0\nArchitecture: amd64\n", "gz", nil)
+			debSHA256  = fmt.Sprintf("%x", sha256.Sum256(deb))
+		)
+
+		JustBeforeEach(func() {
+			Expect(libbuildpack.NewYAML().Write(aptFile, &apt.Apt{})).To(Succeed())
+			Expect(a.Setup()).To(Succeed())
+
+			server = ghttp.NewServer()
+			debURL = server.URL() + "/foo.deb"
+			archiveDir = filepath.Join(cacheDir, "apt", "cache", "archives")
+
+			mockCommand.EXPECT().Output(gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+			DeferCleanup(server.Close)
+		})
+
+		It("accepts a download that matches", func() {
+			server.AppendHandlers(ghttp.RespondWith(200, deb))
+			a.Packages = []apt.Package{{Name: debURL, SHA256: debSHA256}}
+
+			Expect(a.DownloadAll()).To(Succeed())
+			Expect(filepath.Join(archiveDir, "foo.deb")).To(BeARegularFile())
+		})
+
+		It("rejects and removes a download that does not match", func() {
+			server.AppendHandlers(ghttp.RespondWith(200, "tampered"))
+			a.Packages = []apt.Package{{Name: debURL, SHA256: debSHA256}}
+
+			Expect(a.DownloadAll()).To(MatchError(ContainSubstring("could not verify pkg " + debURL + ": sha256 mismatch: expected " + debSHA256)))
+			Expect(filepath.Join(archiveDir, "foo.deb")).NotTo(BeAnExistingFile())
+		})
+
+		It("reuses a cached archive that still matches", func() {
+			Expect(os.WriteFile(filepath.Join(archiveDir, "foo.deb"), deb, 0644)).To(Succeed())
+			a.Packages = []apt.Package{{Name: debURL, SHA256: debSHA256}}
+
+			Expect(a.DownloadAll()).To(Succeed())
+			Expect(server.ReceivedRequests()).To(BeEmpty())
+		})
+
+		It("downloads a cached archive again when it no longer matches", func() {
+			Expect(os.WriteFile(filepath.Join(archiveDir, "foo.deb"), []byte("corrupted foo"), 0644)).To(Succeed())
+			server.AppendHandlers(ghttp.RespondWith(200, deb))
+			a.Packages = []apt.Package{{Name: debURL, SHA256: debSHA256}}
+
+			Expect(a.DownloadAll()).To(Succeed())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+			Expect(os.ReadFile(filepath.Join(archiveDir, "foo.deb"))).To(Equal(deb))
+		})
 	})
 
 	Describe("InstallAll", func() {
+		var archiveDir string
+
 		BeforeEach(func() {
 			var err error
 			cacheDir, err = os.MkdirTemp("", "cachedir")
 			Expect(err).ToNot(HaveOccurred())
-			Expect(os.MkdirAll(filepath.Join(cacheDir, "apt", "cache", "archives"), 0755)).To(Succeed())
-			Expect(os.WriteFile(filepath.Join(cacheDir, "apt", "cache", "archives", "holiday.deb"), []byte{}, 0644)).To(Succeed())
-			Expect(os.WriteFile(filepath.Join(cacheDir, "apt", "cache", "archives", "disneyland.deb"), []byte{}, 0644)).To(Succeed())
+			archiveDir = filepath.Join(cacheDir, "apt", "cache", "archives")
+			Expect(os.MkdirAll(archiveDir, 0755)).To(Succeed())
+			writeDeb(filepath.Join(archiveDir, "holiday_1.0_all.deb"), "Package: holiday\n", "xz", []debEntry{{Name: "./usr/share/holiday", Body: "1.0"}})
+			Expect(os.WriteFile(filepath.Join(archiveDir, "holiday_0.9_all.deb"), []byte{}, 0644)).To(Succeed())
+			writeDeb(filepath.Join(archiveDir, "disneyland_2.0_amd64.deb"), "Package: disneyland\n", "zst", []debEntry{{Name: "./usr/bin/disneyland", Body: "2.0", Mode: 0755}})
+			Expect(os.WriteFile(filepath.Join(archiveDir, "removed_1.0_amd64.deb"), []byte{}, 0644)).To(Succeed())
 		})
 
-		It("installs the downloaded debs", func() {
-			mockCommand.EXPECT().Output("/", "dpkg", "-x", filepath.Join(cacheDir, "apt", "cache", "archives", "holiday.deb"), installDir)
-			mockCommand.EXPECT().Output("/", "dpkg", "-x", filepath.Join(cacheDir, "apt", "cache", "archives", "disneyland.deb"), installDir)
+		JustBeforeEach(func() {
+			Expect(libbuildpack.NewYAML().Write(aptFile, &apt.Apt{Packages: []apt.Package{{Name: "holiday"}, {Name: "disneyland"}}})).To(Succeed())
+			Expect(a.Setup()).To(Succeed())
+
+			mockCommand.EXPECT().Output("/", "apt-get", gomock.Any()).DoAndReturn(func(_, _ string, args ...string) (string, error) {
+				Expect(args).To(ContainElement("--print-uris"))
+				return "'http://example.com/holiday_1.0_all.deb' holiday_1.0_all.deb 0 SHA256:aaaa\n" +
+					"'http://example.com/disneyland_2.0_amd64.deb' disneyland_2.0_amd64.deb 0 SHA256:bbbb\n", nil
+			})
+			mockCommand.EXPECT().Output("/", "apt-get", gomock.Any()).Return("apt output", nil)
+			Expect(a.DownloadAll()).To(Succeed())
+		})
+
+		It("installs the debs resolved for this staging", func() {
+			Expect(a.InstallAll()).To(Succeed())
+
+			Expect(os.ReadFile(filepath.Join(installDir, "usr", "share", "holiday"))).To(Equal([]byte("1.0")))
+			Expect(os.ReadFile(filepath.Join(installDir, "usr", "bin", "disneyland"))).To(Equal([]byte("2.0")))
+		})
+
+		It("removes archives left over from earlier stagings", func() {
 			Expect(a.InstallAll()).To(Succeed())
+
+			Expect(filepath.Join(archiveDir, "holiday_0.9_all.deb")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(archiveDir, "removed_1.0_amd64.deb")).NotTo(BeAnExistingFile())
+			Expect(buffer.String()).To(ContainSubstring("Removing removed_1.0_amd64.deb from the apt cache"))
 		})
 	})
 })
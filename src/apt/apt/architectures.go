@@ -0,0 +1,126 @@
+package apt
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// goArchitectures maps GOARCH to the dpkg architecture, for stacks where dpkg
+// cannot tell.
+var goArchitectures = map[string]string{
+	"amd64":   "amd64",
+	"arm64":   "arm64",
+	"386":     "i386",
+	"ppc64le": "ppc64el",
+	"riscv64": "riscv64",
+	"s390x":   "s390x",
+}
+
+// configureArchitectures adds the architectures listed in apt.yml to those
+// of the stack, so that apt resolves pkg:arch entries and the dependencies of
+// foreign packages. apt only asks dpkg for the architectures when
+// APT::Architectures is unset, so the native one is listed first, followed by
+// the foreign ones of the stack. Setup runs more than once per staging, the
+// options are only added the first time.
+func (a *Apt) configureArchitectures() error {
+	if a.architectures != nil {
+		return nil
+	}
+
+	qualified := a.qualifiedArchitectures()
+	if len(a.AddedArchitectures) == 0 && len(qualified) == 0 {
+		return nil
+	}
+
+	stack, err := a.stackArchitectures()
+	if err != nil {
+		return err
+	}
+	architectures := a.withAddedArchitectures(stack)
+
+	for _, pkg := range qualified {
+		if !slices.Contains(architectures, pkg[1]) {
+			return fmt.Errorf("package %s is for architecture %s, add it to architectures in apt.yml", pkg[0], pkg[1])
+		}
+	}
+
+	a.architectures = architectures
+	if len(architectures) == 1 {
+		return nil
+	}
+
+	a.options = append(a.options, "-o", "APT::Architectures="+strings.Join(architectures, ","))
+	a.logger.Info("Installing packages for architectures %s", strings.Join(architectures, ", "))
+	return nil
+}
+
+// Architectures returns the dpkg architecture of the stack followed by its
+// foreign architectures and those added in apt.yml. dpkg is only asked once
+// per staging.
+func (a *Apt) Architectures() []string {
+	if a.architectures != nil {
+		return a.architectures
+	}
+
+	stack, err := a.stackArchitectures()
+	if err != nil {
+		native := goArchitectures[runtime.GOARCH]
+		a.logger.Warning("Could not determine the dpkg architecture of the stack, assuming %s", native)
+		stack = []string{native}
+	}
+
+	a.architectures = a.withAddedArchitectures(stack)
+	return a.architectures
+}
+
+// stackArchitectures asks dpkg for the native architecture of the stack
+// followed by its foreign architectures.
+func (a *Apt) stackArchitectures() ([]string, error) {
+	out, err := a.command.Output("/", "dpkg", "--print-architecture")
+	native := strings.TrimSpace(out)
+	if err != nil || native == "" {
+		return nil, fmt.Errorf("could not determine the architecture of the stack\n\n%s\n\n%s", out, err)
+	}
+
+	out, err = a.command.Output("/", "dpkg", "--print-foreign-architectures")
+	if err != nil {
+		return nil, fmt.Errorf("could not determine the foreign architectures of the stack\n\n%s\n\n%s", out, err)
+	}
+
+	return append([]string{native}, strings.Fields(out)...), nil
+}
+
+// withAddedArchitectures appends the architectures of apt.yml to those of the
+// stack, dropping duplicates.
+func (a *Apt) withAddedArchitectures(stack []string) []string {
+	var architectures []string
+	for _, arch := range append(stack, a.AddedArchitectures...) {
+		architectures = appendUnique(architectures, arch)
+	}
+	return architectures
+}
+
+// qualifiedArchitectures lists the packages and build packages in apt.yml
+// given as pkg:arch together with their architecture. The arch-independent
+// qualifiers are left out.
+func (a *Apt) qualifiedArchitectures() [][2]string {
+	var qualified [][2]string
+	for _, pkg := range append(slices.Clone(a.Packages), a.BuildPackages...) {
+		if pkg.IsURL() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(pkg.Name, "=")
+		name, _, _ = strings.Cut(name, "/")
+		if _, arch, found := strings.Cut(name, ":"); found {
+			switch arch {
+			case "", "any", "all", "native":
+			default:
+				qualified = append(qualified, [2]string{pkg.Name, arch})
+			}
+		}
+	}
+	return qualified
+}
@@ -0,0 +1,146 @@
+package apt_test
+
+import (
+	"errors"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Architectures", func() {
+	var (
+		f      *aptFixture
+		a      *apt.Apt
+		aptYml *apt.Apt
+	)
+
+	BeforeEach(func() {
+		f = newAptFixture()
+	})
+
+	JustBeforeEach(func() {
+		f.writeAptYml(aptYml)
+		a = f.newApt()
+	})
+
+	expectArchitectures := func(native string, foreign ...string) {
+		f.mockCommand.EXPECT().Output("/", "dpkg", "--print-architecture").Return(native+"\n", nil)
+		out := ""
+		for _, arch := range foreign {
+			out += arch + "\n"
+		}
+		f.mockCommand.EXPECT().Output("/", "dpkg", "--print-foreign-architectures").Return(out, nil)
+	}
+
+	Context("when apt.yml adds no architectures", func() {
+		BeforeEach(func() {
+			aptYml = &apt.Apt{Packages: []apt.Package{{Name: "jq"}}}
+		})
+
+		It("returns the native architecture of the stack followed by its foreign ones", func() {
+			expectArchitectures("amd64", "i386", "amd64")
+			Expect(a.Setup()).To(Succeed())
+
+			Expect(a.Architectures()).To(Equal([]string{"amd64", "i386"}))
+			Expect(a.Architectures()).To(Equal([]string{"amd64", "i386"}))
+		})
+
+		It("assumes the architecture the buildpack runs on when dpkg cannot tell", func() {
+			f.mockCommand.EXPECT().Output("/", "dpkg", "--print-architecture").Return("", errors.New("exit status 127"))
+			Expect(a.Setup()).To(Succeed())
+
+			Expect(a.Architectures()).To(HaveLen(1))
+			Expect(f.buffer.String()).To(ContainSubstring("Could not determine the dpkg architecture of the stack, assuming"))
+		})
+	})
+
+	Context("when apt.yml adds architectures", func() {
+		BeforeEach(func() {
+			aptYml = &apt.Apt{
+				AddedArchitectures: []string{"i386", "amd64"},
+				Packages:           []apt.Package{{Name: "libc6:i386"}, {Name: "jq"}},
+			}
+		})
+
+		It("configures apt for the native and the added architectures", func() {
+			expectArchitectures("amd64")
+			Expect(a.Setup()).To(Succeed())
+			Expect(a.Setup()).To(Succeed())
+
+			Expect(a.Architectures()).To(Equal([]string{"amd64", "i386"}))
+			Expect(f.buffer.String()).To(ContainSubstring("Installing packages for architectures amd64, i386"))
+
+			f.mockCommand.EXPECT().Execute("/", gomock.Any(), gomock.Any(), "apt-get",
+				"-o", "debug::nolocking=true",
+				"-o", "dir::cache="+f.cacheDir+"/apt/cache",
+				"-o", "dir::state="+f.cacheDir+"/apt/state",
+				"-o", "dir::etc::sourcelist="+f.cacheDir+"/apt/sources/sources.list",
+				"-o", "dir::etc::trusted="+f.cacheDir+"/apt/etc/trusted.gpg",
+				"-o", "dir::etc::trustedparts="+f.cacheDir+"/apt/etc/trusted.gpg.d",
+				"-o", "Dir::Etc::preferences="+f.cacheDir+"/apt/etc/preferences",
+				"-o", "APT::Architectures=amd64,i386",
+				"update",
+			).Return(nil)
+			Expect(a.Update()).To(Succeed())
+		})
+
+		It("fails when the architecture of the stack is unknown", func() {
+			f.mockCommand.EXPECT().Output("/", "dpkg", "--print-architecture").Return("dpkg: not found", errors.New("exit status 127"))
+			Expect(a.Setup()).To(MatchError(ContainSubstring("could not determine the architecture of the stack\n\ndpkg: not found")))
+		})
+	})
+
+	Context("when the stack has foreign architectures", func() {
+		BeforeEach(func() {
+			aptYml = &apt.Apt{
+				AddedArchitectures: []string{"armhf"},
+				Packages:           []apt.Package{{Name: "libc6:i386"}},
+			}
+		})
+
+		It("keeps them besides those added in apt.yml", func() {
+			expectArchitectures("amd64", "i386")
+			Expect(a.Setup()).To(Succeed())
+
+			Expect(a.Architectures()).To(Equal([]string{"amd64", "i386", "armhf"}))
+			Expect(f.buffer.String()).To(ContainSubstring("Installing packages for architectures amd64, i386, armhf"))
+		})
+	})
+
+	Context("when a build package is qualified with an architecture that is not added", func() {
+		BeforeEach(func() {
+			aptYml = &apt.Apt{BuildPackages: []apt.Package{{Name: "gcc-multilib:i386"}}}
+		})
+
+		It("fails naming the missing architecture", func() {
+			expectArchitectures("amd64")
+			Expect(a.Setup()).To(MatchError("package gcc-multilib:i386 is for architecture i386, add it to architectures in apt.yml"))
+		})
+	})
+
+	Context("when a package is qualified with an architecture that is not added", func() {
+		BeforeEach(func() {
+			aptYml = &apt.Apt{Packages: []apt.Package{{Name: "libc6:i386=2.35-0ubuntu3"}}}
+		})
+
+		It("fails naming the missing architecture", func() {
+			expectArchitectures("amd64")
+			Expect(a.Setup()).To(MatchError("package libc6:i386=2.35-0ubuntu3 is for architecture i386, add it to architectures in apt.yml"))
+		})
+	})
+
+	Context("when packages are only qualified with the native architecture", func() {
+		BeforeEach(func() {
+			aptYml = &apt.Apt{Packages: []apt.Package{{Name: "libc6:amd64"}, {Name: "python3:any"}}}
+		})
+
+		It("leaves the apt options alone", func() {
+			expectArchitectures("amd64")
+			Expect(a.Setup()).To(Succeed())
+			Expect(a.Architectures()).To(Equal([]string{"amd64"}))
+		})
+	})
+})
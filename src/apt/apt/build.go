@@ -0,0 +1,35 @@
+package apt
+
+import (
+	"fmt"
+	"os"
+)
+
+// BuildPackagesDir is where the build_packages of apt.yml are installed,
+// under the cache dir so that they are not part of the droplet. It is empty
+// when apt.yml has no build packages.
+func (a *Apt) BuildPackagesDir() string {
+	if len(a.BuildPackages) == 0 {
+		return ""
+	}
+	return a.buildInstallDir
+}
+
+// installBuildPackages replaces the build packages installed by an earlier
+// staging, which the cache dir keeps, with those resolved for this one. They
+// are installed in full, exclude_paths only keeps files out of the droplet.
+func (a *Apt) installBuildPackages() error {
+	if err := os.RemoveAll(a.buildInstallDir); err != nil {
+		return err
+	}
+	if len(a.BuildPackages) == 0 {
+		return nil
+	}
+
+	if _, err := a.installPackages(a.resolvedBuild, a.buildInstallDir, nil); err != nil {
+		return fmt.Errorf("failed to install build packages\n\n%s", err)
+	}
+
+	a.logger.Info("Installed %d build packages to %s, they are only available while staging", len(a.resolvedBuild), a.buildInstallDir)
+	return nil
+}
@@ -0,0 +1,96 @@
+package apt_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Build packages", func() {
+	var (
+		f        *aptFixture
+		a        *apt.Apt
+		buildDir string
+	)
+
+	expectResolve := func(archives ...string) {
+		uris := ""
+		for _, archive := range archives {
+			uris += fmt.Sprintf("'http://example.com/pool/%s' %s 0 SHA256:0000\n", archive, archive)
+		}
+		f.mockCommand.EXPECT().Output("/", "apt-get", gomock.Any()).DoAndReturn(func(_, _ string, args ...string) (string, error) {
+			Expect(args).To(ContainElement("--print-uris"))
+			return uris, nil
+		})
+		f.mockCommand.EXPECT().Output("/", "apt-get", gomock.Any()).Return("apt output", nil)
+	}
+
+	BeforeEach(func() {
+		f = newAptFixture()
+		buildDir = filepath.Join(f.cacheDir, "apt", "build")
+
+		f.writeAptYml(&apt.Apt{
+			Packages:      []apt.Package{{Name: "libfoo1"}},
+			BuildPackages: []apt.Package{{Name: "libfoo-dev"}},
+		})
+
+		writeDeb(filepath.Join(f.archiveDir, "libfoo1_1.0_amd64.deb"), "Package: libfoo1\nVersion: 1.0\nArchitecture: amd64\n", "gz", []debEntry{
+			{Name: "./usr/lib/x86_64-linux-gnu/libfoo.so.1", Body: "elf"},
+		})
+		writeDeb(filepath.Join(f.archiveDir, "libfoo-dev_1.0_amd64.deb"), "Package: libfoo-dev\nVersion: 1.0\nArchitecture: amd64\n", "gz", []debEntry{
+			{Name: "./usr/include/foo.h", Body: "int foo();"},
+		})
+	})
+
+	JustBeforeEach(func() {
+		a = f.newApt()
+		Expect(a.Setup()).To(Succeed())
+
+		expectResolve("libfoo1_1.0_amd64.deb")
+		expectResolve("libfoo-dev_1.0_amd64.deb", "libfoo1_1.0_amd64.deb")
+		Expect(a.DownloadAll()).To(Succeed())
+	})
+
+	It("installs build packages under the cache dir and the others into the droplet", func() {
+		Expect(a.InstallAll()).To(Succeed())
+
+		Expect(a.BuildPackagesDir()).To(Equal(buildDir))
+		Expect(filepath.Join(buildDir, "usr", "include", "foo.h")).To(BeAnExistingFile())
+		Expect(filepath.Join(f.installDir, "usr", "include", "foo.h")).NotTo(BeAnExistingFile())
+		Expect(filepath.Join(f.installDir, "usr", "lib", "x86_64-linux-gnu", "libfoo.so.1")).To(BeAnExistingFile())
+		Expect(filepath.Join(buildDir, "usr", "lib", "x86_64-linux-gnu", "libfoo.so.1")).NotTo(BeAnExistingFile())
+
+		status, err := os.ReadFile(filepath.Join(a.StatusDir(), "status"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(status)).NotTo(ContainSubstring("libfoo-dev"))
+	})
+
+	It("removes build packages installed by earlier stagings", func() {
+		Expect(os.MkdirAll(filepath.Join(buildDir, "usr", "include"), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(buildDir, "usr", "include", "old.h"), []byte(""), 0644)).To(Succeed())
+
+		Expect(a.InstallAll()).To(Succeed())
+		Expect(filepath.Join(buildDir, "usr", "include", "old.h")).NotTo(BeAnExistingFile())
+	})
+
+	It("records build packages in apt.lock", func() {
+		lockPath := filepath.Join(f.appDir, "apt.lock")
+		Expect(a.WriteLock(lockPath)).To(Succeed())
+
+		lock := apt.Lockfile{}
+		Expect(libbuildpack.NewYAML().Load(lockPath, &lock)).To(Succeed())
+		Expect(lock.RequestedBuild).To(Equal([]string{"libfoo-dev"}))
+		Expect(lock.Packages).To(HaveLen(2))
+		Expect(lock.Packages[0].Name).To(Equal("libfoo1"))
+		Expect(lock.Packages[0].Build).To(BeFalse())
+		Expect(lock.Packages[1].Name).To(Equal("libfoo-dev"))
+		Expect(lock.Packages[1].Build).To(BeTrue())
+	})
+})
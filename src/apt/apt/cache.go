@@ -0,0 +1,213 @@
+package apt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// cacheManifest records what the apt dir of the cache was filled for, so
+// that Setup can clear the parts that a change makes stale.
+type cacheManifest struct {
+	Stack string `yaml:"stack"`
+	// RootFS digests the os-release and dpkg status of the stack, which
+	// change with every release of its root filesystem
+	RootFS string `yaml:"rootfs"`
+	// AptYml digests the entries of apt.yml that decide which package lists
+	// are fetched and which keys are trusted
+	AptYml string `yaml:"apt_yml"`
+	// EtcApt digests the sources, keys and preferences of the stack
+	EtcApt string `yaml:"etc_apt"`
+}
+
+// aptYmlSources are the entries of apt.yml that cacheManifest digests.
+type aptYmlSources struct {
+	TruncateSources    bool
+	Keys               []Key
+	GpgAdvancedOptions []string
+	Repos              []Repository
+	Architectures      []string
+}
+
+// invalidateCache clears the parts of the cache dir that were filled for
+// another stack or configuration and records what they are filled for now.
+func (a *Apt) invalidateCache() error {
+	current, err := a.currentCacheManifest()
+	if err != nil {
+		return fmt.Errorf("could not digest apt configuration\n\n%s", err)
+	}
+
+	manifestPath := filepath.Join(filepath.Dir(a.cacheDir), "manifest.yml")
+
+	found := false
+	previous := current
+	if exists, err := libbuildpack.FileExists(manifestPath); err != nil {
+		return err
+	} else if exists {
+		previous = cacheManifest{}
+		if err := libbuildpack.NewYAML().Load(manifestPath, &previous); err != nil {
+			a.logger.Warning("Could not read the apt cache manifest: %s", err)
+		} else {
+			found = true
+		}
+	}
+
+	all := a.cacheParts()
+	lists, keys, build := a.stateDir, filepath.Dir(a.trustedKeys), a.buildInstallDir
+
+	cleared := map[string]bool{}
+	for _, change := range []struct {
+		changed bool
+		reason  string
+		parts   []string
+	}{
+		{!found, "it has no manifest of what it was written for", []string{lists, keys, build}},
+		{previous.Stack != current.Stack, fmt.Sprintf("the stack changed from %s to %s", previous.Stack, current.Stack), all},
+		{previous.RootFS != current.RootFS, "the root filesystem of the stack changed", all},
+		{previous.EtcApt != current.EtcApt, "the apt sources, keys or preferences of the stack changed", []string{lists, keys}},
+		{previous.AptYml != current.AptYml, "the repos, keys or architectures in apt.yml changed", []string{lists, keys}},
+	} {
+		if !change.changed {
+			continue
+		}
+
+		var parts []string
+		for _, part := range change.parts {
+			if !cleared[part] {
+				cleared[part] = true
+				parts = append(parts, part)
+			}
+		}
+		if err := a.clearCache(change.reason, parts...); err != nil {
+			return err
+		}
+	}
+
+	return libbuildpack.NewYAML().Write(manifestPath, current)
+}
+
+// cacheParts are the dirs under the apt dir of the cache that Setup may
+// clear: archives, package lists, keys and build packages.
+func (a *Apt) cacheParts() []string {
+	return []string{a.cacheDir, a.stateDir, filepath.Dir(a.trustedKeys), a.buildInstallDir}
+}
+
+// clearCache removes the given parts of the cache, logging reason if any of
+// them has content.
+func (a *Apt) clearCache(reason string, parts ...string) error {
+	var cleared []string
+	for _, part := range parts {
+		if entries, err := os.ReadDir(part); err != nil || len(entries) == 0 {
+			continue
+		}
+		if err := os.RemoveAll(part); err != nil {
+			return err
+		}
+		cleared = append(cleared, cachePartNames[filepath.Base(part)])
+	}
+
+	if len(cleared) > 0 {
+		a.logger.Info("Clearing %s from the apt cache, %s", strings.Join(cleared, ", "), reason)
+	}
+	return nil
+}
+
+var cachePartNames = map[string]string{
+	"cache": "archives",
+	"state": "package lists",
+	"etc":   "keys",
+	"build": "build packages",
+}
+
+func (a *Apt) currentCacheManifest() (cacheManifest, error) {
+	sources := fmt.Sprintf("%+v", aptYmlSources{
+		TruncateSources:    a.TruncateSources,
+		Keys:               a.Keys,
+		GpgAdvancedOptions: a.GpgAdvancedOptions,
+		Repos:              a.Repos,
+		Architectures:      a.AddedArchitectures,
+	})
+	aptYml, err := digest([]string{sources}, nil)
+	if err != nil {
+		return cacheManifest{}, err
+	}
+
+	etcFiles, err := dirFiles(filepath.Join(a.rootDir, "trusted.gpg.d"))
+	if err != nil {
+		return cacheManifest{}, err
+	}
+	etcFiles = append([]string{
+		filepath.Join(a.rootDir, "sources.list"),
+		filepath.Join(a.rootDir, "trusted.gpg"),
+		filepath.Join(a.rootDir, "preferences"),
+	}, etcFiles...)
+	etcApt, err := digest(nil, etcFiles)
+	if err != nil {
+		return cacheManifest{}, err
+	}
+
+	rootFS, err := digest(nil, []string{a.stackPath("etc", "os-release"), a.stackPath("var", "lib", "dpkg", "status")})
+	if err != nil {
+		return cacheManifest{}, err
+	}
+
+	return cacheManifest{
+		Stack:  os.Getenv("CF_STACK"),
+		RootFS: rootFS,
+		AptYml: aptYml,
+		EtcApt: etcApt,
+	}, nil
+}
+
+// stackPath returns a path on the root filesystem of the stack, on which
+// rootDir is /etc/apt.
+func (a *Apt) stackPath(path ...string) string {
+	return filepath.Join(append([]string{filepath.Dir(filepath.Dir(a.rootDir))}, path...)...)
+}
+
+// digest hashes lines and the contents of those files at paths that exist,
+// so that a change to any of them can be noticed.
+func digest(lines []string, paths []string) (string, error) {
+	hash := sha256.New()
+	for _, line := range lines {
+		fmt.Fprintf(hash, "%s\n", line)
+	}
+
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(hash, "file %s\n", path)
+		_, err = io.Copy(hash, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// dirFiles lists the files in dirs, sorted.
+func dirFiles(dirs ...string) ([]string, error) {
+	var paths []string
+	for _, dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+		paths = append(paths, files...)
+	}
+	return paths, nil
+}
@@ -0,0 +1,135 @@
+package apt_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Cache manifest", func() {
+	var (
+		f     *aptFixture
+		repos []apt.Repository
+	)
+
+	aptCache := func(path ...string) string {
+		return filepath.Join(append([]string{f.cacheDir, "apt"}, path...)...)
+	}
+
+	// stage sets up apt as a new staging with the same cache dir would
+	stage := func() error {
+		f.writeAptYml(&apt.Apt{
+			Packages: []apt.Package{{Name: "curl"}},
+			Repos:    repos,
+		})
+		return f.newApt().Setup()
+	}
+
+	// fillCache leaves files in every part of the cache, as a staging would
+	fillCache := func() {
+		for _, path := range [][]string{
+			{"cache", "archives", "curl_7.81_amd64.deb"},
+			{"state", "lists", "archive.ubuntu.com_ubuntu_dists_jammy_InRelease"},
+			{"etc", "keyrings", "example.gpg"},
+			{"build", "usr", "include", "curl.h"},
+		} {
+			Expect(os.MkdirAll(filepath.Dir(aptCache(path...)), 0755)).To(Succeed())
+			Expect(os.WriteFile(aptCache(path...), []byte("cached"), 0644)).To(Succeed())
+		}
+	}
+
+	// writeStackFile writes a file to the root filesystem of the stack
+	writeStackFile := func(path, content string) {
+		Expect(os.MkdirAll(filepath.Dir(filepath.Join(f.stackDir, path)), 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(f.stackDir, path), []byte(content), 0644)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		f = newAptFixture()
+		repos = nil
+
+		f.writeSourcesList("deb http://archive.ubuntu.com/ubuntu jammy main\n")
+		writeStackFile("etc/os-release", "ID=ubuntu\nVERSION=\"22.04.4 LTS (Jammy Jellyfish)\"\n")
+		writeStackFile("var/lib/dpkg/status", "Package: curl\nStatus: install ok installed\nVersion: 7.81.0-1ubuntu1.15\n")
+		GinkgoT().Setenv("CF_STACK", "cflinuxfs4")
+	})
+
+	It("records what the cache is filled for", func() {
+		Expect(stage()).To(Succeed())
+
+		manifest, err := os.ReadFile(aptCache("manifest.yml"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(manifest)).To(ContainSubstring("stack: cflinuxfs4\n"))
+		Expect(string(manifest)).To(MatchRegexp(`rootfs: [0-9a-f]{64}\n`))
+		Expect(string(manifest)).To(MatchRegexp(`apt_yml: [0-9a-f]{64}\n`))
+		Expect(string(manifest)).To(MatchRegexp(`etc_apt: [0-9a-f]{64}\n`))
+	})
+
+	It("keeps the cache when nothing changed", func() {
+		Expect(stage()).To(Succeed())
+		fillCache()
+
+		Expect(stage()).To(Succeed())
+		Expect(f.buffer.String()).NotTo(ContainSubstring("Clearing"))
+		Expect(aptCache("state", "lists", "archive.ubuntu.com_ubuntu_dists_jammy_InRelease")).To(BeAnExistingFile())
+		Expect(aptCache("build", "usr", "include", "curl.h")).To(BeAnExistingFile())
+	})
+
+	It("clears the whole cache when the stack changed", func() {
+		GinkgoT().Setenv("CF_STACK", "cflinuxfs3")
+		Expect(stage()).To(Succeed())
+		fillCache()
+
+		GinkgoT().Setenv("CF_STACK", "cflinuxfs4")
+		Expect(stage()).To(Succeed())
+		Expect(f.buffer.String()).To(ContainSubstring("Clearing archives, package lists, keys, build packages from the apt cache, the stack changed from cflinuxfs3 to cflinuxfs4"))
+		Expect(aptCache("cache", "archives", "curl_7.81_amd64.deb")).NotTo(BeAnExistingFile())
+		Expect(aptCache("build")).NotTo(BeADirectory())
+	})
+
+	It("clears the whole cache when the root filesystem of the stack changed", func() {
+		Expect(stage()).To(Succeed())
+		fillCache()
+
+		writeStackFile("var/lib/dpkg/status", "Package: curl\nStatus: install ok installed\nVersion: 7.81.0-1ubuntu1.16\n")
+		Expect(stage()).To(Succeed())
+		Expect(f.buffer.String()).To(ContainSubstring("Clearing archives, package lists, keys, build packages from the apt cache, the root filesystem of the stack changed"))
+		Expect(aptCache("cache", "archives", "curl_7.81_amd64.deb")).NotTo(BeAnExistingFile())
+	})
+
+	It("clears package lists and keys when the repos in apt.yml changed", func() {
+		repos = []apt.Repository{{Name: "deb http://apt.example.com stable main"}}
+		Expect(stage()).To(Succeed())
+		fillCache()
+
+		repos = nil
+		Expect(stage()).To(Succeed())
+		Expect(f.buffer.String()).To(ContainSubstring("Clearing package lists, keys from the apt cache, the repos, keys or architectures in apt.yml changed"))
+		Expect(aptCache("state", "lists", "archive.ubuntu.com_ubuntu_dists_jammy_InRelease")).NotTo(BeAnExistingFile())
+		Expect(aptCache("etc", "keyrings", "example.gpg")).NotTo(BeAnExistingFile())
+		Expect(aptCache("cache", "archives", "curl_7.81_amd64.deb")).To(BeAnExistingFile())
+	})
+
+	It("clears package lists and keys when /etc/apt of the stack changed", func() {
+		Expect(stage()).To(Succeed())
+		fillCache()
+
+		f.writeSourcesList("deb http://archive.ubuntu.com/ubuntu jammy main universe\n")
+		Expect(stage()).To(Succeed())
+		Expect(f.buffer.String()).To(ContainSubstring("Clearing package lists, keys from the apt cache, the apt sources, keys or preferences of the stack changed"))
+		Expect(aptCache("cache", "archives", "curl_7.81_amd64.deb")).To(BeAnExistingFile())
+	})
+
+	It("keeps only the archives of a cache without a manifest", func() {
+		fillCache()
+
+		Expect(stage()).To(Succeed())
+		Expect(f.buffer.String()).To(ContainSubstring("Clearing package lists, keys, build packages from the apt cache, it has no manifest of what it was written for"))
+		Expect(aptCache("cache", "archives", "curl_7.81_amd64.deb")).To(BeAnExistingFile())
+		Expect(aptCache("manifest.yml")).To(BeAnExistingFile())
+	})
+})
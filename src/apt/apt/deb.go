@@ -0,0 +1,489 @@
+package apt
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const arMagic = "!<arch>\n"
+
+// arReader iterates over the members of an ar archive, the container format
+// of .deb files.
+type arReader struct {
+	r       *bufio.Reader
+	current io.Reader
+	pad     int64
+}
+
+type arHeader struct {
+	Name string
+	Size int64
+}
+
+func newArReader(r io.Reader) (*arReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != arMagic {
+		return nil, fmt.Errorf("not a debian archive")
+	}
+	return &arReader{r: br}, nil
+}
+
+func (ar *arReader) Next() (*arHeader, error) {
+	if ar.current != nil {
+		if _, err := io.Copy(io.Discard, ar.current); err != nil {
+			return nil, err
+		}
+	}
+	if ar.pad > 0 {
+		if _, err := ar.r.Discard(int(ar.pad)); err != nil {
+			return nil, err
+		}
+	}
+
+	header := make([]byte, 60)
+	if _, err := io.ReadFull(ar.r, header); err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("invalid ar header: %s", err)
+	}
+	if string(header[58:60]) != "`\n" {
+		return nil, fmt.Errorf("invalid ar header")
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ar member size: %s", err)
+	}
+
+	ar.current = io.LimitReader(ar.r, size)
+	ar.pad = size % 2
+
+	return &arHeader{
+		Name: strings.TrimSuffix(strings.TrimSpace(string(header[0:16])), "/"),
+		Size: size,
+	}, nil
+}
+
+func (ar *arReader) Read(p []byte) (int, error) {
+	if ar.current == nil {
+		return 0, io.EOF
+	}
+	return ar.current.Read(p)
+}
+
+// decompress wraps a data.tar or control.tar member according to the
+// compression named by its extension.
+func decompress(name string, r io.Reader) (io.Reader, func(), error) {
+	noop := func() {}
+
+	switch filepath.Ext(name) {
+	case ".tar":
+		return r, noop, nil
+	case ".gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, func() { gz.Close() }, nil
+	case ".xz":
+		xzr, err := xz.NewReader(r)
+		return xzr, noop, err
+	case ".lzma":
+		lr, err := lzma.NewReader(r)
+		return lr, noop, err
+	case ".zst":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	case ".bz2":
+		return bzip2.NewReader(r), noop, nil
+	default:
+		return nil, nil, fmt.Errorf("unsupported compression of %s", name)
+	}
+}
+
+// readControl returns the fields of the control file of a .deb, like
+// dpkg-deb -f.
+func readControl(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ar, err := newArReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no control member found")
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(header.Name, "control.tar") {
+			continue
+		}
+
+		r, closer, err := decompress(header.Name, ar)
+		if err != nil {
+			return nil, err
+		}
+		defer closer()
+
+		tr := tar.NewReader(r)
+		for {
+			entry, err := tr.Next()
+			if err == io.EOF {
+				return nil, fmt.Errorf("no control file found")
+			} else if err != nil {
+				return nil, err
+			}
+
+			if filepath.Clean("/"+entry.Name) != "/control" {
+				continue
+			}
+
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, err
+			}
+			return parseControl(string(content)), nil
+		}
+	}
+}
+
+// installTree is an install dir that packages are extracted into in
+// parallel. It remembers which package placed each file, so that a path
+// shipped by several packages ends up with the content of the last one in
+// package order, as if they were extracted one after the other.
+type installTree struct {
+	dir    string
+	mu     sync.Mutex
+	owners map[string]int
+}
+
+func newInstallTree(dir string) *installTree {
+	return &installTree{dir: dir, owners: map[string]int{}}
+}
+
+// place renames tmp to name for the package at position order, unless a
+// later package already placed name.
+func (t *installTree) place(name, tmp string, order int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if owner, ok := t.owners[name]; ok && owner > order {
+		return os.Remove(tmp)
+	}
+	t.owners[name] = order
+
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// debContents is what dpkg would record about an unpacked package: its
+// control paragraph, md5sums, postinst script and the paths it installed.
+type debContents struct {
+	Control  string
+	MD5Sums  string
+	Postinst string
+	Files    []string
+	// Excluded counts the files of Files that the path filter left out
+	Excluded int
+	// Size is the number of bytes of the regular files written
+	Size int64
+}
+
+// extractDeb unpacks the data member of a .deb into tree, like dpkg -x.
+// File modes, symlinks and hardlinks are preserved, ownership is not. order
+// is the position of the package among those extracted into tree. Files
+// excluded by filter are not written, but still listed like dpkg does.
+func extractDeb(file string, tree *installTree, order int, filter *pathFilter) (*debContents, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ar, err := newArReader(f)
+	if err != nil {
+		return nil, err
+	}
+
+	contents := &debContents{}
+	for {
+		header, err := ar.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no data member found")
+		} else if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(header.Name, "control.tar") && !strings.HasPrefix(header.Name, "data.tar") {
+			continue
+		}
+
+		r, closer, err := decompress(header.Name, ar)
+		if err != nil {
+			return nil, err
+		}
+		defer closer()
+
+		if strings.HasPrefix(header.Name, "control.tar") {
+			if err := readControlTar(tar.NewReader(r), contents); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err := extractTar(tar.NewReader(r), tree, order, filter, contents); err != nil {
+			return nil, err
+		}
+		return contents, nil
+	}
+}
+
+func readControlTar(tr *tar.Reader, contents *debContents) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		var field *string
+		switch filepath.Clean(header.Name) {
+		case "control":
+			field = &contents.Control
+		case "md5sums":
+			field = &contents.MD5Sums
+		case "postinst":
+			field = &contents.Postinst
+		default:
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return err
+		}
+		*field = string(data)
+	}
+}
+
+// extractTar unpacks tr into tree and records in contents the extracted
+// paths as dpkg lists them, relative to the install dir, how many of them
+// filter left out and how much space they take.
+func extractTar(tr *tar.Reader, tree *installTree, order int, filter *pathFilter, contents *debContents) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name, err := safeJoin(tree.dir, header.Name)
+		if err != nil {
+			return err
+		}
+
+		// a hardlink cannot be created when its target was left out
+		if filter.excluded(header.Name) || (header.Typeflag == tar.TypeLink && filter.excluded(header.Linkname)) {
+			if header.Typeflag != tar.TypeDir {
+				contents.Excluded++
+			}
+			contents.Files = append(contents.Files, filepath.Clean("/"+header.Name))
+			continue
+		}
+
+		mode := header.FileInfo().Mode()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(name, 0755); err != nil {
+				return err
+			}
+			if err := os.Chmod(name, mode.Perm()|0700); err != nil {
+				return err
+			}
+
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			tmp, err := writeTemp(name, tr, mode, header.ModTime)
+			if err != nil {
+				return err
+			}
+			if err := tree.place(name, tmp, order); err != nil {
+				return err
+			}
+			contents.Size += header.Size
+
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			tmp, err := linkTemp(name, func(tmp string) error { return os.Symlink(header.Linkname, tmp) })
+			if err != nil {
+				return err
+			}
+			if err := tree.place(name, tmp, order); err != nil {
+				return err
+			}
+
+		case tar.TypeLink:
+			target, err := safeJoin(tree.dir, header.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			tmp, err := linkTemp(name, func(tmp string) error { return os.Link(target, tmp) })
+			if err != nil {
+				return err
+			}
+			if err := tree.place(name, tmp, order); err != nil {
+				return err
+			}
+
+		default:
+			continue
+		}
+
+		if listed := filepath.Clean("/" + header.Name); listed != "/" {
+			contents.Files = append(contents.Files, listed)
+		} else {
+			contents.Files = append(contents.Files, "/.")
+		}
+	}
+}
+
+func excludedFiles(installed []*debContents) int {
+	excluded := 0
+	for _, contents := range installed {
+		if contents != nil {
+			excluded += contents.Excluded
+		}
+	}
+	return excluded
+}
+
+// writeTemp writes r to a temporary file next to name and returns its path.
+// Renaming it into place replaces a symlink or hardlink at name rather than
+// writing through it, and packages extracted in parallel that share a path,
+// such as Multi-Arch: same ones, never see it missing or half written.
+func writeTemp(name string, r io.Reader, mode os.FileMode, modTime time.Time) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return "", err
+	}
+	tmp := f.Name()
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Chmod(tmp, mode&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky)); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Chtimes(tmp, modTime, modTime); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+
+	return tmp, nil
+}
+
+// linkTemp creates a link at a temporary name next to name and returns that
+// name.
+func linkTemp(name string, create func(string) error) (string, error) {
+	for {
+		tmp := filepath.Join(filepath.Dir(name), fmt.Sprintf(".%s.%d", filepath.Base(name), rand.Int63()))
+		err := create(tmp)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		return tmp, err
+	}
+}
+
+// replace creates a link at a temporary name next to name and renames it
+// into place.
+func replace(name string, create func(string) error) error {
+	tmp, err := linkTemp(name, create)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// safeJoin resolves an archive path below destDir. Besides rejecting paths
+// that leave destDir, it rejects paths below a symlink, which an earlier
+// entry or package could point anywhere.
+func safeJoin(destDir, name string) (string, error) {
+	path := filepath.Join(destDir, filepath.Clean("/"+name))
+	if path != destDir && !strings.HasPrefix(path, destDir+string(os.PathSeparator)) {
+		return "", fmt.Errorf("invalid path %s in archive", name)
+	}
+
+	parent := destDir
+	for _, part := range strings.Split(strings.TrimPrefix(filepath.Dir(path), destDir), string(os.PathSeparator)) {
+		if part == "" {
+			continue
+		}
+		parent = filepath.Join(parent, part)
+
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("invalid path %s in archive, %s is a symlink", name, strings.TrimPrefix(parent, destDir+string(os.PathSeparator)))
+		}
+	}
+
+	return path, nil
+}
@@ -0,0 +1,278 @@
+package apt_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	"github.com/golang/mock/gomock"
+	"github.com/klauspost/compress/zstd"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/ulikunitz/xz"
+)
+
+type debEntry struct {
+	Name     string
+	Body     string
+	Mode     int64
+	Linkname string
+	Type     byte
+}
+
+func tarball(entries []debEntry) []byte {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:     entry.Name,
+			Mode:     entry.Mode,
+			Linkname: entry.Linkname,
+			Typeflag: entry.Type,
+			Size:     int64(len(entry.Body)),
+			ModTime:  time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		}
+		if header.Typeflag == 0 {
+			header.Typeflag = tar.TypeReg
+		}
+		if header.Typeflag != tar.TypeReg {
+			header.Size = 0
+		}
+		if header.Mode == 0 {
+			header.Mode = 0644
+		}
+		Expect(tw.WriteHeader(header)).To(Succeed())
+		if header.Size > 0 {
+			_, err := tw.Write([]byte(entry.Body))
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+	Expect(tw.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+func compress(compression string, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	var w io.WriteCloser
+	var err error
+
+	switch compression {
+	case "":
+		return data
+	case "gz":
+		w = gzip.NewWriter(buf)
+	case "xz":
+		w, err = xz.NewWriter(buf)
+	case "zst":
+		w, err = zstd.NewWriter(buf)
+	}
+	Expect(err).NotTo(HaveOccurred())
+
+	_, err = w.Write(data)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(w.Close()).To(Succeed())
+	return buf.Bytes()
+}
+
+// debArchive builds a .deb with the given control file and data entries, the
+// data member compressed as named. Further control members, such as
+// maintainer scripts, can be added.
+func debArchive(control, compression string, entries []debEntry, controlEntries ...debEntry) []byte {
+	members := []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", compress("gz", tarball(append([]debEntry{{Name: "./control", Body: control}}, controlEntries...)))},
+		{strings.TrimSuffix("data.tar."+compression, "."), compress(compression, tarball(entries))},
+	}
+
+	buf := bytes.NewBufferString("!<arch>\n")
+	for _, member := range members {
+		fmt.Fprintf(buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", member.name, 0, 0, 0, 0644, len(member.data))
+		buf.Write(member.data)
+		if len(member.data)%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+
+	return buf.Bytes()
+}
+
+func writeDeb(path, control, compression string, entries []debEntry, controlEntries ...debEntry) {
+	Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+	Expect(os.WriteFile(path, debArchive(control, compression, entries, controlEntries...), 0644)).To(Succeed())
+}
+
+// resolveArchives lets DownloadAll resolve exactly the given archive names,
+// as if apt had printed them with --print-uris.
+func resolveArchives(mockCommand *MockCommand, a *apt.Apt, archives ...string) {
+	uris := ""
+	for _, archive := range archives {
+		uris += fmt.Sprintf("'http://example.com/pool/%s' %s 0 SHA256:0000\n", archive, archive)
+	}
+
+	mockCommand.EXPECT().Output("/", "apt-get", gomock.Any()).DoAndReturn(func(_, _ string, args ...string) (string, error) {
+		Expect(args).To(ContainElement("--print-uris"))
+		return uris, nil
+	})
+	mockCommand.EXPECT().Output("/", "apt-get", gomock.Any()).Return("apt output", nil)
+	Expect(a.DownloadAll()).To(Succeed())
+}
+
+var _ = Describe("Deb extraction", func() {
+	var (
+		f *aptFixture
+		a *apt.Apt
+	)
+
+	BeforeEach(func() {
+		f = newAptFixture()
+		f.writeAptYml(&apt.Apt{Packages: []apt.Package{{Name: "foo"}}})
+	})
+
+	JustBeforeEach(func() {
+		a = f.newApt()
+		Expect(a.Setup()).To(Succeed())
+	})
+
+	DescribeTable("extracts the data member without dpkg",
+		func(compression string) {
+			writeDeb(filepath.Join(f.archiveDir, "foo_1.0_amd64.deb"), "Package: foo\n", compression, []debEntry{
+				{Name: "./usr/", Type: tar.TypeDir, Mode: 0755},
+				{Name: "./usr/bin/", Type: tar.TypeDir, Mode: 0755},
+				{Name: "./usr/bin/foo", Body: "#!/bin/sh\necho foo\n", Mode: 0755},
+			})
+			resolveArchives(f.mockCommand, a, "foo_1.0_amd64.deb")
+
+			Expect(a.InstallAll()).To(Succeed())
+			Expect(os.ReadFile(filepath.Join(f.installDir, "usr", "bin", "foo"))).To(Equal([]byte("#!/bin/sh\necho foo\n")))
+		},
+		Entry("uncompressed", ""),
+		Entry("gzip", "gz"),
+		Entry("xz", "xz"),
+		Entry("zstd", "zst"),
+	)
+
+	It("keeps modes, symlinks and hardlinks", func() {
+		writeDeb(filepath.Join(f.archiveDir, "foo_1.0_amd64.deb"), "Package: foo\n", "zst", []debEntry{
+			{Name: "./usr/lib/x86_64-linux-gnu/libfoo.so.1.0", Body: "elf", Mode: 0644},
+			{Name: "./usr/lib/x86_64-linux-gnu/libfoo.so.1", Type: tar.TypeSymlink, Linkname: "libfoo.so.1.0"},
+			{Name: "./usr/bin/foo", Body: "binary", Mode: 0755},
+			{Name: "./usr/bin/foo-alias", Type: tar.TypeLink, Linkname: "./usr/bin/foo"},
+		})
+		resolveArchives(f.mockCommand, a, "foo_1.0_amd64.deb")
+
+		Expect(a.InstallAll()).To(Succeed())
+
+		info, err := os.Stat(filepath.Join(f.installDir, "usr", "bin", "foo"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+
+		link, err := os.Readlink(filepath.Join(f.installDir, "usr", "lib", "x86_64-linux-gnu", "libfoo.so.1"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(link).To(Equal("libfoo.so.1.0"))
+
+		alias, err := os.Stat(filepath.Join(f.installDir, "usr", "bin", "foo-alias"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(os.SameFile(info, alias)).To(BeTrue())
+	})
+
+	It("extracts several packages in parallel", func() {
+		archives := []string{}
+		for i := 0; i < 8; i++ {
+			archive := fmt.Sprintf("pkg%d_1.0_amd64.deb", i)
+			writeDeb(filepath.Join(f.archiveDir, archive), fmt.Sprintf("Package: pkg%d\n", i), "xz", []debEntry{
+				{Name: "./usr/share/", Type: tar.TypeDir, Mode: 0755},
+				{Name: fmt.Sprintf("./usr/share/pkg%d", i), Body: "content"},
+			})
+			archives = append(archives, archive)
+		}
+		resolveArchives(f.mockCommand, a, archives...)
+
+		Expect(a.InstallAll()).To(Succeed())
+		for i := 0; i < 8; i++ {
+			Expect(filepath.Join(f.installDir, "usr", "share", fmt.Sprintf("pkg%d", i))).To(BeARegularFile())
+		}
+	})
+
+	It("extracts packages that share paths in parallel", func() {
+		archives := []string{}
+		for i := 0; i < 8; i++ {
+			archive := fmt.Sprintf("libfoo_1.0_arch%d.deb", i)
+			writeDeb(filepath.Join(f.archiveDir, archive), "Package: libfoo\nMulti-Arch: same\n", "gz", []debEntry{
+				{Name: "./usr/share/doc/libfoo/changelog", Body: "changes"},
+				{Name: "./usr/share/doc/libfoo/NEWS", Type: tar.TypeLink, Linkname: "./usr/share/doc/libfoo/changelog"},
+				{Name: "./usr/share/doc/libfoo/README", Type: tar.TypeSymlink, Linkname: "changelog"},
+			})
+			archives = append(archives, archive)
+		}
+		resolveArchives(f.mockCommand, a, archives...)
+
+		Expect(a.InstallAll()).To(Succeed())
+
+		docDir := filepath.Join(f.installDir, "usr", "share", "doc", "libfoo")
+		Expect(os.ReadFile(filepath.Join(docDir, "NEWS"))).To(Equal([]byte("changes")))
+		Expect(os.ReadFile(filepath.Join(docDir, "README"))).To(Equal([]byte("changes")))
+		files, err := os.ReadDir(docDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(3))
+	})
+
+	It("refuses entries that would escape the install dir", func() {
+		writeDeb(filepath.Join(f.archiveDir, "foo_1.0_amd64.deb"), "Package: foo\n", "gz", []debEntry{
+			{Name: "../../escaped", Body: "nope"},
+		})
+		resolveArchives(f.mockCommand, a, "foo_1.0_amd64.deb")
+
+		Expect(a.InstallAll()).To(Succeed())
+		Expect(filepath.Join(f.installDir, "escaped")).To(BeARegularFile())
+		Expect(filepath.Join(f.cacheDir, "escaped")).NotTo(BeAnExistingFile())
+	})
+
+	It("lets the last package in resolved order win where packages ship the same path", func() {
+		archives := []string{}
+		for i := 0; i < 8; i++ {
+			archive := fmt.Sprintf("pkg%d_1.0_amd64.deb", i)
+			writeDeb(filepath.Join(f.archiveDir, archive), fmt.Sprintf("Package: pkg%d\n", i), "gz", []debEntry{
+				{Name: "./etc/shared.conf", Body: fmt.Sprintf("from pkg%d", i)},
+				{Name: "./etc/shared.link", Type: tar.TypeSymlink, Linkname: fmt.Sprintf("pkg%d", i)},
+			})
+			archives = append(archives, archive)
+		}
+		resolveArchives(f.mockCommand, a, archives...)
+
+		Expect(a.InstallAll()).To(Succeed())
+		Expect(os.ReadFile(filepath.Join(f.installDir, "etc", "shared.conf"))).To(Equal([]byte("from pkg7")))
+		Expect(os.Readlink(filepath.Join(f.installDir, "etc", "shared.link"))).To(Equal("pkg7"))
+	})
+
+	It("refuses entries below a symlink", func() {
+		outside := filepath.Join(f.cacheDir, "outside")
+		Expect(os.MkdirAll(outside, 0755)).To(Succeed())
+		writeDeb(filepath.Join(f.archiveDir, "foo_1.0_amd64.deb"), "Package: foo\n", "gz", []debEntry{
+			{Name: "./usr/lib/evil", Type: tar.TypeSymlink, Linkname: outside},
+			{Name: "./usr/lib/evil/payload", Body: "nope"},
+		})
+		resolveArchives(f.mockCommand, a, "foo_1.0_amd64.deb")
+
+		Expect(a.InstallAll()).To(MatchError(ContainSubstring("invalid path ./usr/lib/evil/payload in archive, usr/lib/evil is a symlink")))
+		Expect(filepath.Join(outside, "payload")).NotTo(BeAnExistingFile())
+	})
+
+	It("names the package that could not be extracted", func() {
+		Expect(os.MkdirAll(f.archiveDir, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(f.archiveDir, "foo_1.0_amd64.deb"), []byte("not a deb"), 0644)).To(Succeed())
+		resolveArchives(f.mockCommand, a, "foo_1.0_amd64.deb")
+
+		Expect(a.InstallAll()).To(MatchError(ContainSubstring("failed to install pkg foo_1.0_amd64.deb")))
+	})
+})
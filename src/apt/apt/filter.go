@@ -0,0 +1,133 @@
+package apt
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// pathPresets are the named sets of globs that exclude_paths and
+// include_paths accept besides globs of their own.
+var pathPresets = map[string][]string{
+	"docs":        {"/usr/share/doc/*", "/usr/share/info/*", "/usr/share/gtk-doc/*", "/usr/share/help/*", "/usr/share/lintian/*"},
+	"man":         {"/usr/share/man/*"},
+	"locales":     {"/usr/share/locale/*"},
+	"static-libs": {"/usr/lib/*.a", "/lib/*.a"},
+}
+
+// keptPaths are installed whatever the filters say. Copyright files carry
+// the license terms the packages are distributed under.
+var keptPaths = []string{"/usr/share/doc/*/copyright"}
+
+// pathFilter decides which files of a package are installed, like dpkg's
+// path-exclude and path-include options: a file matching an exclude glob is
+// left out unless it also matches an include glob.
+type pathFilter struct {
+	exclude []*regexp.Regexp
+	include []*regexp.Regexp
+	kept    []*regexp.Regexp
+}
+
+// newPathFilter validates both lists, and returns a nil filter, which leaves
+// nothing out, when there is nothing to exclude.
+func newPathFilter(exclude, include []string) (*pathFilter, error) {
+	f := &pathFilter{}
+	var err error
+	if f.exclude, err = pathGlobs("exclude_paths", exclude); err != nil {
+		return nil, err
+	}
+	if f.include, err = pathGlobs("include_paths", include); err != nil {
+		return nil, err
+	}
+	if len(f.exclude) == 0 {
+		return nil, nil
+	}
+
+	if f.kept, err = pathGlobs("", keptPaths); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// excluded tells whether the file at path, relative to the install root, is
+// left out.
+func (f *pathFilter) excluded(path string) bool {
+	if f == nil {
+		return false
+	}
+
+	path = filepath.Clean("/" + path)
+	return matchesAny(f.exclude, path) && !matchesAny(f.include, path) && !matchesAny(f.kept, path)
+}
+
+func pathGlobs(option string, entries []string) ([]*regexp.Regexp, error) {
+	var globs []*regexp.Regexp
+	for _, entry := range entries {
+		patterns := []string{entry}
+		if !strings.HasPrefix(entry, "/") {
+			preset, found := pathPresets[entry]
+			if !found {
+				return nil, fmt.Errorf("%s has %s, which is neither an absolute path glob nor one of the presets %s", option, entry, strings.Join(presetNames(), ", "))
+			}
+			patterns = preset
+		}
+
+		for _, pattern := range patterns {
+			glob, err := globRegexp(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s has an invalid glob %s: %s", option, pattern, err)
+			}
+			globs = append(globs, glob)
+		}
+	}
+	return globs, nil
+}
+
+// globRegexp translates a glob in which, as for dpkg, * and ? also match /.
+func globRegexp(glob string) (*regexp.Regexp, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			expr.WriteString(".*")
+		case '?':
+			expr.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("missing ]")
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
+
+func matchesAny(globs []*regexp.Regexp, path string) bool {
+	for _, glob := range globs {
+		if glob.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func presetNames() []string {
+	names := make([]string, 0, len(pathPresets))
+	for name := range pathPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,130 @@
+package apt_test
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Path filters", func() {
+	var (
+		f            *aptFixture
+		a            *apt.Apt
+		excludePaths []string
+		includePaths []string
+	)
+
+	BeforeEach(func() {
+		f = newAptFixture()
+		excludePaths, includePaths = []string{"docs", "man", "static-libs", "/usr/share/vim/*/tutor/*"}, []string{"/usr/share/doc/vim/README*"}
+
+		writeDeb(filepath.Join(f.archiveDir, "vim_9.0_amd64.deb"), "Package: vim\nVersion: 9.0\nArchitecture: amd64\n", "gz", []debEntry{
+			{Name: "./usr/bin/vim", Body: "elf", Mode: 0755},
+			{Name: "./usr/bin/vi", Linkname: "vim", Type: tar.TypeSymlink},
+			{Name: "./usr/lib/libvim.a", Body: "archive"},
+			{Name: "./usr/lib/libvim.so", Body: "elf"},
+			{Name: "./usr/share/doc/vim/", Type: tar.TypeDir},
+			{Name: "./usr/share/doc/vim/changelog.gz", Body: "changes"},
+			{Name: "./usr/share/doc/vim/copyright", Body: "license"},
+			{Name: "./usr/share/doc/vim/README.txt", Body: "readme"},
+			{Name: "./usr/share/man/man1/vim.1.gz", Body: "manual"},
+			{Name: "./usr/share/man/man1/vi.1.gz", Linkname: "./usr/share/man/man1/vim.1.gz", Type: tar.TypeLink},
+			{Name: "./usr/share/vim/vim90/tutor/tutor", Body: "tutor"},
+		})
+	})
+
+	JustBeforeEach(func() {
+		f.writeAptYml(&apt.Apt{
+			Packages:     []apt.Package{{Name: "vim"}},
+			ExcludePaths: excludePaths,
+			IncludePaths: includePaths,
+		})
+		a = f.newApt()
+	})
+
+	Context("with valid paths", func() {
+		JustBeforeEach(func() {
+			Expect(a.Setup()).To(Succeed())
+
+			f.mockCommand.EXPECT().Output("/", "apt-get", gomock.Any()).Return("'http://example.com/vim_9.0_amd64.deb' vim_9.0_amd64.deb 0 SHA256:0000\n", nil)
+			f.mockCommand.EXPECT().Output("/", "apt-get", gomock.Any()).Return("apt output", nil)
+			Expect(a.DownloadAll()).To(Succeed())
+		})
+
+		It("leaves out excluded files unless they are included", func() {
+			Expect(a.InstallAll()).To(Succeed())
+
+			Expect(filepath.Join(f.installDir, "usr", "bin", "vim")).To(BeAnExistingFile())
+			Expect(filepath.Join(f.installDir, "usr", "bin", "vi")).To(BeAnExistingFile())
+			Expect(filepath.Join(f.installDir, "usr", "lib", "libvim.so")).To(BeAnExistingFile())
+			Expect(filepath.Join(f.installDir, "usr", "share", "doc", "vim", "README.txt")).To(BeAnExistingFile())
+
+			Expect(filepath.Join(f.installDir, "usr", "lib", "libvim.a")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(f.installDir, "usr", "share", "doc", "vim", "changelog.gz")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(f.installDir, "usr", "share", "man")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(f.installDir, "usr", "share", "vim", "vim90", "tutor")).NotTo(BeAnExistingFile())
+
+			Expect(f.buffer.String()).To(ContainSubstring("Left out 5 files matching exclude_paths in apt.yml"))
+		})
+
+		It("always keeps copyright files", func() {
+			Expect(a.InstallAll()).To(Succeed())
+			Expect(os.ReadFile(filepath.Join(f.installDir, "usr", "share", "doc", "vim", "copyright"))).To(Equal([]byte("license")))
+		})
+
+		It("still lists excluded files in the dpkg file list", func() {
+			Expect(a.InstallAll()).To(Succeed())
+
+			list, err := os.ReadFile(filepath.Join(a.StatusDir(), "info", "vim.list"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(list)).To(ContainSubstring("/usr/share/man/man1/vim.1.gz\n"))
+			Expect(string(list)).To(ContainSubstring("/usr/lib/libvim.a\n"))
+		})
+	})
+
+	Context("when only include_paths are given", func() {
+		BeforeEach(func() {
+			excludePaths = nil
+		})
+
+		It("installs every file and warns that include_paths has no effect", func() {
+			Expect(a.Setup()).To(Succeed())
+			Expect(f.buffer.String()).To(ContainSubstring("include_paths in apt.yml has no effect without exclude_paths"))
+
+			f.mockCommand.EXPECT().Output("/", "apt-get", gomock.Any()).Return("'http://example.com/vim_9.0_amd64.deb' vim_9.0_amd64.deb 0 SHA256:0000\n", nil)
+			f.mockCommand.EXPECT().Output("/", "apt-get", gomock.Any()).Return("apt output", nil)
+			Expect(a.DownloadAll()).To(Succeed())
+			Expect(a.InstallAll()).To(Succeed())
+
+			Expect(filepath.Join(f.installDir, "usr", "share", "doc", "vim", "changelog.gz")).To(BeAnExistingFile())
+			Expect(filepath.Join(f.installDir, "usr", "share", "man", "man1", "vim.1.gz")).To(BeAnExistingFile())
+			Expect(f.buffer.String()).NotTo(ContainSubstring("Left out"))
+		})
+
+		Context("and one of them is invalid", func() {
+			BeforeEach(func() {
+				includePaths = []string{"readme"}
+			})
+
+			It("fails naming the entry", func() {
+				Expect(a.Setup()).To(MatchError(ContainSubstring("include_paths has readme, which is neither an absolute path glob nor one of the presets")))
+			})
+		})
+	})
+
+	Context("when an entry is neither a glob nor a preset", func() {
+		BeforeEach(func() {
+			excludePaths = []string{"usr/share/doc/*", "docs"}
+		})
+
+		It("fails listing the presets", func() {
+			Expect(a.Setup()).To(MatchError("invalid paths in apt.yml\n\nexclude_paths has usr/share/doc/*, which is neither an absolute path glob nor one of the presets docs, locales, man, static-libs"))
+		})
+	})
+})
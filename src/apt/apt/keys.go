@@ -0,0 +1,287 @@
+package apt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// Key is an OpenPGP key fetched from a URL. When Fingerprints are given,
+// the fetched key is only trusted if it matches one of them.
+type Key struct {
+	URL          string   `yaml:"url"`
+	Fingerprints []string `yaml:"fingerprints,omitempty"`
+}
+
+func (k *Key) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var keyURL string
+	if err := unmarshal(&keyURL); err == nil {
+		k.URL = keyURL
+		return nil
+	}
+
+	data := struct {
+		URL          string
+		Fingerprint  string
+		Fingerprints []string
+	}{}
+	err := unmarshal(&data)
+	if err != nil {
+		return err
+	}
+
+	k.URL = data.URL
+	k.Fingerprints = data.Fingerprints
+	if data.Fingerprint != "" {
+		k.Fingerprints = append([]string{data.Fingerprint}, k.Fingerprints...)
+	}
+	return nil
+}
+
+func (a *Apt) AddKeys() error {
+	for _, options := range a.GpgAdvancedOptions {
+		if err := a.addKeysFromOptions(options); err != nil {
+			return fmt.Errorf("could not pass gpg advanced options %s\n\n%s", options, err)
+		}
+	}
+
+	for _, key := range a.Keys {
+		keys, err := fetchKeys(key.URL)
+		if err == nil {
+			err = verifyFingerprints(key, keys)
+		}
+		if err != nil {
+			return fmt.Errorf("could not add apt key %s: %s", key.URL, err)
+		}
+
+		if _, err := writeKeyring(a.trustedParts, keys); err != nil {
+			return fmt.Errorf("could not add apt key %s: %s", key.URL, err)
+		}
+	}
+
+	return nil
+}
+
+// repoKeyring writes the key of a single repo to a keyring outside of
+// trusted.gpg.d, so that it is only used through signed-by.
+func (a *Apt) repoKeyring(repo Repository) (string, error) {
+	keys, err := fetchKeys(repo.Key.URL)
+	if err == nil {
+		err = verifyFingerprints(repo.Key, keys)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not add key %s for repo %s: %s", repo.Key.URL, repo.Name, err)
+	}
+
+	keyring, err := writeKeyring(a.keyrings, keys)
+	if err != nil {
+		return "", fmt.Errorf("could not add key %s for repo %s: %s", repo.Key.URL, repo.Name, err)
+	}
+
+	return keyring, nil
+}
+
+// addKeysFromOptions supports the subset of gpg options that was commonly
+// passed to apt-key adv: fetching keys by id from a keyserver.
+func (a *Apt) addKeysFromOptions(options string) error {
+	var keyserver string
+	var keyIDs []string
+
+	args := strings.Fields(options)
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--keyserver":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--keyserver requires a value")
+			}
+			i++
+			keyserver = args[i]
+		case "--recv-keys", "--recv-key":
+			keyIDs = append(keyIDs, args[i+1:]...)
+			i = len(args)
+		default:
+			return fmt.Errorf("unsupported gpg option %s, only --keyserver and --recv-keys are supported", args[i])
+		}
+	}
+
+	if keyserver == "" || len(keyIDs) == 0 {
+		return fmt.Errorf("both --keyserver and --recv-keys are required")
+	}
+
+	for _, keyID := range keyIDs {
+		keyURL, err := keyserverURL(keyserver, keyID)
+		if err != nil {
+			return err
+		}
+
+		keys, err := fetchKeys(keyURL)
+		if err != nil {
+			return fmt.Errorf("could not fetch key %s from %s: %s", keyID, keyURL, err)
+		}
+
+		keys = matchingKeys(keys, keyID)
+		if len(keys) == 0 {
+			return fmt.Errorf("%s returned no key matching %s", keyURL, keyID)
+		}
+
+		if _, err := writeKeyring(a.trustedParts, keys); err != nil {
+			return fmt.Errorf("could not add key %s: %s", keyID, err)
+		}
+	}
+
+	return nil
+}
+
+// keyserverURL builds an HKP lookup URL. A bare host is queried over hkps,
+// the hkp scheme uses the HKP port.
+func keyserverURL(keyserver, keyID string) (string, error) {
+	if !strings.Contains(keyserver, "://") {
+		keyserver = "hkps://" + keyserver
+	}
+
+	u, err := url.Parse(keyserver)
+	if err != nil {
+		return "", fmt.Errorf("invalid keyserver %s: %s", keyserver, err)
+	}
+
+	switch u.Scheme {
+	case "hkp":
+		u.Scheme = "http"
+		if u.Port() == "" {
+			u.Host += ":11371"
+		}
+	case "hkps":
+		u.Scheme = "https"
+	case "http", "https":
+	default:
+		return "", fmt.Errorf("unsupported keyserver scheme %s", u.Scheme)
+	}
+
+	keyID = normalizeKeyID(keyID)
+	u.Path = "/pks/lookup"
+	u.RawQuery = url.Values{"op": {"get"}, "options": {"mr"}, "search": {"0x" + keyID}}.Encode()
+
+	return u.String(), nil
+}
+
+func normalizeKeyID(keyID string) string {
+	return strings.TrimPrefix(strings.ToUpper(keyID), "0X")
+}
+
+// matchingKeys drops the keys a keyserver returned besides the requested one.
+// Key IDs and fingerprints both match the end of a fingerprint, of either the
+// primary key or one of its subkeys.
+func matchingKeys(keys openpgp.EntityList, keyID string) openpgp.EntityList {
+	keyID = normalizeKeyID(keyID)
+	matches := func(fingerprint []byte) bool {
+		return strings.HasSuffix(fmt.Sprintf("%X", fingerprint), keyID)
+	}
+
+	var matching openpgp.EntityList
+	for _, key := range keys {
+		if matches(key.PrimaryKey.Fingerprint) || slices.ContainsFunc(key.Subkeys, func(subkey openpgp.Subkey) bool {
+			return matches(subkey.PublicKey.Fingerprint)
+		}) {
+			matching = append(matching, key)
+		}
+	}
+	return matching
+}
+
+func fetchKeys(keyURL string) (openpgp.EntityList, error) {
+	resp, err := http.Get(keyURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return readKeys(data)
+}
+
+// readKeys parses both ASCII armored and binary OpenPGP public keys.
+func readKeys(data []byte) (openpgp.EntityList, error) {
+	var keys openpgp.EntityList
+	var err error
+
+	if bytes.Contains(data, []byte("-----BEGIN PGP")) {
+		keys, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keys, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse OpenPGP key: %s", err)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no OpenPGP keys found")
+	}
+
+	return keys, nil
+}
+
+// verifyFingerprints checks that every key fetched for key is one of its
+// pinned fingerprints. Keys without pinned fingerprints are not checked.
+func verifyFingerprints(key Key, keys openpgp.EntityList) error {
+	if len(key.Fingerprints) == 0 {
+		return nil
+	}
+
+	expected := map[string]bool{}
+	for _, fingerprint := range key.Fingerprints {
+		expected[normalizeFingerprint(fingerprint)] = true
+	}
+
+	for _, entity := range keys {
+		actual := fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint)
+		if !expected[actual] {
+			return fmt.Errorf("fingerprint mismatch: expected %s, actual %s", strings.Join(key.Fingerprints, " or "), actual)
+		}
+	}
+
+	return nil
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	fingerprint = strings.ToUpper(strings.ReplaceAll(fingerprint, " ", ""))
+	return strings.TrimPrefix(fingerprint, "0X")
+}
+
+// writeKeyring stores keys as a binary keyring named after the fingerprint
+// of the first key, which apt accepts in trusted.gpg.d and signed-by.
+func writeKeyring(dir string, keys openpgp.EntityList) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%X.gpg", keys[0].PrimaryKey.Fingerprint))
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	for _, key := range keys {
+		if err := key.Serialize(f); err != nil {
+			return "", err
+		}
+	}
+
+	return path, nil
+}
@@ -0,0 +1,236 @@
+package apt_test
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/cloudfoundry/libbuildpack/cutlass"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("Keys", func() {
+	var (
+		a            *apt.Apt
+		mockCtrl     *gomock.Controller
+		mockCommand  *MockCommand
+		cacheDir     string
+		trustedParts string
+		armoredKey   []byte
+		fingerprint  string
+		keyID        string
+		server       *ghttp.Server
+	)
+
+	BeforeEach(func() {
+		bpDir, err := cutlass.FindRoot()
+		Expect(err).NotTo(HaveOccurred())
+
+		armoredKey, err = os.ReadFile(filepath.Join(bpDir, "fixtures", "repo", "gpg_public_key"))
+		Expect(err).NotTo(HaveOccurred())
+
+		keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(armoredKey))
+		Expect(err).NotTo(HaveOccurred())
+		fingerprint = fmt.Sprintf("%X", keys[0].PrimaryKey.Fingerprint)
+		keyID = fingerprint[len(fingerprint)-16:]
+
+		cacheDir, _ = os.MkdirTemp("", "cachedir")
+		trustedParts = filepath.Join(cacheDir, "apt", "etc", "trusted.gpg.d")
+
+		server = ghttp.NewServer()
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockCommand = NewMockCommand(mockCtrl)
+		DeferCleanup(func() {
+			server.Close()
+			os.RemoveAll(cacheDir)
+		})
+	})
+
+	JustBeforeEach(func() {
+		a = apt.New(mockCommand, "apt.yml", "/etc/apt", cacheDir, filepath.Join(cacheDir, "install"), libbuildpack.NewLogger(GinkgoWriter))
+	})
+
+	expectKeyring := func() {
+		data, err := os.ReadFile(filepath.Join(trustedParts, fingerprint+".gpg"))
+		Expect(err).NotTo(HaveOccurred())
+
+		keys, err := openpgp.ReadKeyRing(bytes.NewReader(data))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(keys).To(HaveLen(1))
+		Expect(fmt.Sprintf("%X", keys[0].PrimaryKey.Fingerprint)).To(Equal(fingerprint))
+	}
+
+	Describe("AddKeys", func() {
+		Context("Keys have been specified", func() {
+			It("writes armored keys to a binary keyring without apt-key", func() {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/public.key"),
+					ghttp.RespondWith(http.StatusOK, armoredKey),
+				))
+				a.Keys = []apt.Key{{URL: server.URL() + "/public.key"}}
+
+				Expect(a.AddKeys()).To(Succeed())
+				expectKeyring()
+			})
+
+			It("accepts binary keys", func() {
+				block, err := armor.Decode(bytes.NewReader(armoredKey))
+				Expect(err).NotTo(HaveOccurred())
+				binaryKey := new(bytes.Buffer)
+				_, err = binaryKey.ReadFrom(block.Body)
+				Expect(err).NotTo(HaveOccurred())
+
+				server.AppendHandlers(ghttp.RespondWith(http.StatusOK, binaryKey.Bytes()))
+				a.Keys = []apt.Key{{URL: server.URL() + "/public.gpg"}}
+
+				Expect(a.AddKeys()).To(Succeed())
+				expectKeyring()
+			})
+
+			It("names the key URL when it cannot be fetched", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, ""))
+				a.Keys = []apt.Key{{URL: server.URL() + "/missing.key"}}
+
+				Expect(a.AddKeys()).To(MatchError("could not add apt key " + server.URL() + "/missing.key: unexpected response 404 Not Found"))
+			})
+
+			It("names the key URL when it is not a key", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusOK, "<html>not a key</html>"))
+				a.Keys = []apt.Key{{URL: server.URL() + "/index.html"}}
+
+				Expect(a.AddKeys()).To(MatchError(ContainSubstring("could not add apt key " + server.URL() + "/index.html: could not parse OpenPGP key")))
+			})
+		})
+
+		Context("Keys with pinned fingerprints have been specified", func() {
+			BeforeEach(func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusOK, armoredKey))
+			})
+
+			It("trusts the key when its fingerprint matches", func() {
+				a.Keys = []apt.Key{{URL: server.URL() + "/public.key", Fingerprints: []string{"0123456789ABCDEF0123456789ABCDEF01234567", strings.ToLower(fingerprint)}}}
+
+				Expect(a.AddKeys()).To(Succeed())
+				expectKeyring()
+			})
+
+			It("fails naming the URL, expected and actual fingerprint on a mismatch", func() {
+				a.Keys = []apt.Key{{URL: server.URL() + "/public.key", Fingerprints: []string{"0123456789ABCDEF0123456789ABCDEF01234567"}}}
+
+				Expect(a.AddKeys()).To(MatchError("could not add apt key " + server.URL() + "/public.key: fingerprint mismatch: expected 0123456789ABCDEF0123456789ABCDEF01234567, actual " + fingerprint))
+				Expect(filepath.Join(trustedParts, fingerprint+".gpg")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		Context("GPG Advanced Options have been specified", func() {
+			It("fetches the keys from the keyserver", func() {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/pks/lookup", "op=get&options=mr&search=0x"+keyID),
+					ghttp.RespondWith(http.StatusOK, armoredKey),
+				))
+				a.GpgAdvancedOptions = []string{"--keyserver " + server.URL() + " --recv-keys " + keyID}
+
+				Expect(a.AddKeys()).To(Succeed())
+				expectKeyring()
+			})
+
+			It("queries a keyserver given as a bare host over hkps", func() {
+				tlsServer := ghttp.NewTLSServer()
+				defaultClient := http.DefaultClient
+				http.DefaultClient = tlsServer.HTTPTestServer.Client()
+				DeferCleanup(func() {
+					http.DefaultClient = defaultClient
+					tlsServer.Close()
+				})
+
+				tlsServer.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/pks/lookup", "op=get&options=mr&search=0x"+keyID),
+					ghttp.RespondWith(http.StatusOK, armoredKey),
+				))
+				a.GpgAdvancedOptions = []string{"--keyserver " + tlsServer.Addr() + " --recv-keys " + keyID}
+
+				Expect(a.AddKeys()).To(Succeed())
+				expectKeyring()
+			})
+
+			It("ignores returned keys that do not match the requested id", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusOK, armoredKey))
+				a.GpgAdvancedOptions = []string{"--keyserver " + server.URL() + " --recv-keys 0123456789ABCDEF"}
+
+				Expect(a.AddKeys()).To(MatchError(ContainSubstring("returned no key matching 0123456789ABCDEF")))
+				Expect(trustedParts).NotTo(BeADirectory())
+			})
+
+			It("accepts the full fingerprint of the key", func() {
+				server.AppendHandlers(ghttp.RespondWith(http.StatusOK, armoredKey))
+				a.GpgAdvancedOptions = []string{"--keyserver " + server.URL() + " --recv-keys 0x" + fingerprint}
+
+				Expect(a.AddKeys()).To(Succeed())
+				expectKeyring()
+			})
+
+			It("rejects options it cannot emulate", func() {
+				a.GpgAdvancedOptions = []string{"--import /tmp/key"}
+
+				Expect(a.AddKeys()).To(MatchError(ContainSubstring("unsupported gpg option --import")))
+			})
+		})
+
+		Context("No keys specified", func() {
+			It("does nothing", func() {
+				Expect(a.AddKeys()).To(Succeed())
+				Expect(trustedParts).NotTo(BeADirectory())
+			})
+		})
+	})
+
+	Describe("AddRepos", func() {
+		var sourceList string
+
+		BeforeEach(func() {
+			sourceList = filepath.Join(cacheDir, "apt", "sources", "sources.list")
+			Expect(os.MkdirAll(filepath.Dir(sourceList), 0777)).To(Succeed())
+			Expect(os.WriteFile(sourceList, []byte("deb http://archive.ubuntu.com/ubuntu jammy main"), 0666)).To(Succeed())
+		})
+
+		It("trusts a repo key only for the source line of that repo", func() {
+			server.RouteToHandler("GET", "/repo.key", ghttp.RespondWith(http.StatusOK, armoredKey))
+			a.Repos = []apt.Repository{
+				{Name: "deb http://apt.example.com stable main", Key: apt.Key{URL: server.URL() + "/repo.key"}},
+				{Name: "deb [arch=amd64] http://other.example.com stable main", Key: apt.Key{URL: server.URL() + "/repo.key"}},
+				{Name: "deb http://unsigned.example.com stable main"},
+			}
+
+			Expect(a.AddRepos()).To(Succeed())
+
+			keyring := filepath.Join(cacheDir, "apt", "etc", "keyrings", fingerprint+".gpg")
+			Expect(keyring).To(BeARegularFile())
+			Expect(filepath.Join(trustedParts, fingerprint+".gpg")).NotTo(BeAnExistingFile())
+
+			content, err := os.ReadFile(sourceList)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal("deb http://archive.ubuntu.com/ubuntu jammy main" +
+				"\ndeb [signed-by=" + keyring + "] http://apt.example.com stable main" +
+				"\ndeb [arch=amd64 signed-by=" + keyring + "] http://other.example.com stable main" +
+				"\ndeb http://unsigned.example.com stable main"))
+		})
+
+		It("names the repo and key URL when the key cannot be fetched", func() {
+			server.AppendHandlers(ghttp.RespondWith(http.StatusNotFound, ""))
+			a.Repos = []apt.Repository{{Name: "deb http://apt.example.com stable main", Key: apt.Key{URL: server.URL() + "/missing.key"}}}
+
+			Expect(a.AddRepos()).To(MatchError(ContainSubstring("could not add key " + server.URL() + "/missing.key for repo deb http://apt.example.com stable main")))
+		})
+	})
+})
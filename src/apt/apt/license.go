@@ -0,0 +1,213 @@
+package apt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// PackageLicenses is the license summary of one installed package, as
+// written to licenses.yml.
+type PackageLicenses struct {
+	Name         string   `yaml:"name"`
+	Version      string   `yaml:"version"`
+	Architecture string   `yaml:"architecture"`
+	Licenses     []string `yaml:"licenses"`
+}
+
+// WriteLicenseReport writes the licenses of every installed package to
+// licenses.yml in dir and logs the distinct licenses shipped. It fails when a
+// package is only available under licenses denied in apt.yml.
+func (a *Apt) WriteLicenseReport(dir string) error {
+	report := make([]PackageLicenses, 0, len(a.resolved))
+	seen := map[string]bool{}
+	var ids []string
+	var denied []string
+
+	for _, pkg := range a.resolved {
+		licenses := a.packageLicenses(pkg.Name)
+		report = append(report, PackageLicenses{
+			Name:         pkg.Name,
+			Version:      pkg.Version,
+			Architecture: pkg.Architecture,
+			Licenses:     licenses,
+		})
+
+		for _, license := range licenses {
+			for _, id := range licenseIDs(license) {
+				if !seen[id] {
+					seen[id] = true
+					ids = append(ids, id)
+				}
+			}
+			if a.licenseDenied(license) {
+				denied = append(denied, fmt.Sprintf("%s %s: %s", pkg.Name, pkg.Version, license))
+			}
+		}
+	}
+
+	path := filepath.Join(dir, "licenses.yml")
+	if err := libbuildpack.NewYAML().Write(path, report); err != nil {
+		return fmt.Errorf("could not write license report: %s", err)
+	}
+
+	sort.Strings(ids)
+	if len(ids) > 0 {
+		a.logger.Info("Licenses of installed packages: %s", strings.Join(ids, ", "))
+	}
+
+	if len(denied) > 0 {
+		return fmt.Errorf("packages are licensed under licenses denied in apt.yml:\n%s", strings.Join(denied, "\n"))
+	}
+
+	return nil
+}
+
+// packageLicenses returns the distinct licenses named in the copyright file
+// of an installed package, either a machine readable (DEP-5) file or free
+// text referring to well known licenses.
+func (a *Apt) packageLicenses(name string) []string {
+	data, err := os.ReadFile(filepath.Join(a.installDir, "usr", "share", "doc", name, "copyright"))
+	if err != nil {
+		return nil
+	}
+
+	content := strings.ReplaceAll(string(data), "\r\n", "\n")
+	if licenses, ok := dep5Licenses(content); ok {
+		return licenses
+	}
+	return textLicenses(content)
+}
+
+func dep5Licenses(content string) ([]string, bool) {
+	paragraphs := strings.Split(content, "\n\n")
+	if !strings.Contains(parseControl(paragraphs[0])["Format"], "copyright-format") {
+		return nil, false
+	}
+
+	licenses := []string{}
+	for _, paragraph := range paragraphs[1:] {
+		fields := parseControl(paragraph)
+		if fields["Files"] == "" {
+			continue
+		}
+
+		license, _, _ := strings.Cut(fields["License"], "\n")
+		licenses = appendUnique(licenses, strings.TrimSpace(license))
+	}
+
+	return licenses, true
+}
+
+var (
+	commonLicense = regexp.MustCompile(`/usr/share/common-licenses/([A-Za-z0-9.+-]*[A-Za-z0-9+])`)
+	textPatterns  = []struct {
+		pattern *regexp.Regexp
+		license string
+	}{
+		{regexp.MustCompile(`(?i)GNU Library General Public\s+License,?\s+version\s+2`), "LGPL-2"},
+		{regexp.MustCompile(`(?i)GNU Lesser General Public\s+License,?\s+version\s+2\.1`), "LGPL-2.1"},
+		{regexp.MustCompile(`(?i)GNU Lesser General Public\s+License,?\s+version\s+3`), "LGPL-3"},
+		{regexp.MustCompile(`(?i)GNU General Public\s+License,?\s+version\s+2`), "GPL-2"},
+		{regexp.MustCompile(`(?i)GNU General Public\s+License,?\s+version\s+3`), "GPL-3"},
+		{regexp.MustCompile(`(?i)Apache License,?\s+Version\s+2\.0`), "Apache-2.0"},
+		{regexp.MustCompile(`(?i)Permission is hereby granted, free of charge`), "MIT"},
+		{regexp.MustCompile(`(?i)Redistribution and use in source and binary forms`), "BSD"},
+	}
+)
+
+// textLicenses recognizes licenses in free text copyright files by their
+// reference to /usr/share/common-licenses or by well known wording.
+func textLicenses(content string) []string {
+	licenses := []string{}
+
+	for _, match := range commonLicense.FindAllStringSubmatch(content, -1) {
+		licenses = appendUnique(licenses, match[1])
+	}
+
+	for _, text := range textPatterns {
+		if text.pattern.MatchString(content) && !containsLicenseID(licenses, text.license) {
+			licenses = appendUnique(licenses, text.license)
+		}
+	}
+
+	return licenses
+}
+
+func containsLicenseID(licenses []string, id string) bool {
+	for _, license := range licenses {
+		if canonicalLicense(license) == canonicalLicense(id) {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	licenseSeparator   = regexp.MustCompile(`(?i)\s+(or|and)\s+|,\s*`)
+	licenseAlternative = regexp.MustCompile(`(?i)\s+or\s+`)
+)
+
+// licenseIDs splits a license expression such as "GPL-2+ or Artistic" into
+// the licenses it names.
+func licenseIDs(license string) []string {
+	var ids []string
+	for _, id := range licenseSeparator.Split(license, -1) {
+		if id = strings.Trim(id, " ()"); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// licenseDenied reports whether license only offers denied licenses: an
+// "or" expression is allowed as long as one alternative is not denied.
+func (a *Apt) licenseDenied(license string) bool {
+	if len(a.LicenseDenylist) == 0 {
+		return false
+	}
+
+	for _, alternative := range licenseAlternative.Split(license, -1) {
+		if !a.anyDenied(licenseIDs(alternative)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *Apt) anyDenied(ids []string) bool {
+	for _, id := range ids {
+		for _, denied := range a.LicenseDenylist {
+			if canonicalLicense(id) == canonicalLicense(denied) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var licenseVersionSuffix = regexp.MustCompile(`(\.0)?(\+|-or-later|-only)?$`)
+
+// canonicalLicense lets Debian and SPDX spellings of a license compare equal,
+// eg GPL-3, GPL-3+, GPL-3.0 and GPL-3.0-or-later.
+func canonicalLicense(id string) string {
+	id, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(id)), " with ")
+	return licenseVersionSuffix.ReplaceAllString(id, "")
+}
+
+func appendUnique(values []string, value string) []string {
+	if value == "" {
+		return values
+	}
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,100 @@
+package apt_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	"github.com/cloudfoundry/libbuildpack"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteLicenseReport", func() {
+	var (
+		f         *aptFixture
+		a         *apt.Apt
+		reportDir string
+		denylist  []string
+	)
+
+	dep5 := "Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/\n\n" +
+		"Files: *\nCopyright: 2024 Foo\nLicense: GPL-3+\n\n" +
+		"Files: lib/*\nCopyright: 2024 Foo\nLicense: LGPL-2.1+ or MIT\n\n" +
+		"License: GPL-3+\n On Debian systems, see /usr/share/common-licenses/GPL-3.\n"
+
+	freeText := "This package was debianized by Bar.\n\n" +
+		"Licensed under the Apache License, Version 2.0 (the \"License\").\n\n" +
+		"On Debian systems, the complete text of the Apache License can be found in\n" +
+		"`/usr/share/common-licenses/Apache-2.0'.\n"
+
+	BeforeEach(func() {
+		f = newAptFixture()
+		reportDir = filepath.Join(f.cacheDir, "report")
+		denylist = nil
+
+		Expect(os.MkdirAll(reportDir, 0755)).To(Succeed())
+
+		writeDeb(filepath.Join(f.archiveDir, "foo_1.0_amd64.deb"), "Package: foo\n", "gz", []debEntry{
+			{Name: "./usr/share/doc/foo/copyright", Body: dep5},
+		})
+		writeDeb(filepath.Join(f.archiveDir, "bar_2.0_all.deb"), "Package: bar\n", "gz", []debEntry{
+			{Name: "./usr/share/doc/bar/copyright", Body: freeText},
+		})
+		writeDeb(filepath.Join(f.archiveDir, "baz_3.0_all.deb"), "Package: baz\n", "gz", []debEntry{
+			{Name: "./usr/share/baz", Body: "no copyright"},
+		})
+	})
+
+	JustBeforeEach(func() {
+		f.writeAptYml(&apt.Apt{
+			Packages:        []apt.Package{{Name: "foo"}, {Name: "bar"}, {Name: "baz"}},
+			LicenseDenylist: denylist,
+		})
+
+		a = f.newApt()
+		Expect(a.Setup()).To(Succeed())
+		resolveArchives(f.mockCommand, a, "foo_1.0_amd64.deb", "bar_2.0_all.deb", "baz_3.0_all.deb")
+		Expect(a.InstallAll()).To(Succeed())
+	})
+
+	It("writes the licenses of each package", func() {
+		Expect(a.WriteLicenseReport(reportDir)).To(Succeed())
+
+		report := []apt.PackageLicenses{}
+		Expect(libbuildpack.NewYAML().Load(filepath.Join(reportDir, "licenses.yml"), &report)).To(Succeed())
+		Expect(report).To(Equal([]apt.PackageLicenses{
+			{Name: "foo", Version: "1.0", Architecture: "amd64", Licenses: []string{"GPL-3+", "LGPL-2.1+ or MIT"}},
+			{Name: "bar", Version: "2.0", Architecture: "all", Licenses: []string{"Apache-2.0"}},
+			{Name: "baz", Version: "3.0", Architecture: "all", Licenses: []string{}},
+		}))
+	})
+
+	It("prints the distinct licenses", func() {
+		Expect(a.WriteLicenseReport(reportDir)).To(Succeed())
+		Expect(f.buffer.String()).To(ContainSubstring("Licenses of installed packages: Apache-2.0, GPL-3+, LGPL-2.1+, MIT"))
+	})
+
+	Context("when apt.yml denies a license", func() {
+		BeforeEach(func() {
+			denylist = []string{"GPL-3.0"}
+		})
+
+		It("fails naming the packages under that license", func() {
+			err := a.WriteLicenseReport(reportDir)
+			Expect(err).To(MatchError("packages are licensed under licenses denied in apt.yml:\nfoo 1.0: GPL-3+"))
+			Expect(filepath.Join(reportDir, "licenses.yml")).To(BeARegularFile())
+		})
+	})
+
+	Context("when apt.yml denies only one alternative of a license", func() {
+		BeforeEach(func() {
+			denylist = []string{"LGPL-2.1"}
+		})
+
+		It("succeeds", func() {
+			Expect(a.WriteLicenseReport(reportDir)).To(Succeed())
+		})
+	})
+})
@@ -0,0 +1,332 @@
+package apt
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// LockedPackage is a single .deb resolved during staging, either from a
+// configured repo or from a direct URL in apt.yml.
+type LockedPackage struct {
+	Name         string `yaml:"name"`
+	Version      string `yaml:"version"`
+	Architecture string `yaml:"architecture"`
+	Source       string `yaml:"source"`
+	SHA256       string `yaml:"sha256"`
+	Build        bool   `yaml:"build,omitempty"`
+	file         string
+}
+
+// Lockfile is the content of apt.lock. Requested, RequestedBuild, SHA256,
+// Repos, TruncateSources, ReinstallStackPackages and Architectures hold the
+// entries of the apt.yml the lock was resolved from, so a stale lock can be
+// detected. SHA256 maps .deb URLs to their expected sha256.
+type Lockfile struct {
+	Requested              []string          `yaml:"requested"`
+	RequestedBuild         []string          `yaml:"requested_build,omitempty"`
+	SHA256                 map[string]string `yaml:"sha256,omitempty"`
+	Repos                  []Repository      `yaml:"repos,omitempty"`
+	TruncateSources        bool              `yaml:"truncatesources,omitempty"`
+	ReinstallStackPackages bool              `yaml:"reinstall_stack_packages,omitempty"`
+	Architectures          []string          `yaml:"architectures,omitempty"`
+	Packages               []LockedPackage   `yaml:"packages"`
+}
+
+func (a *Apt) loadLock() error {
+	a.lock = nil
+
+	if exists, err := libbuildpack.FileExists(a.lockFilePath); err != nil {
+		return err
+	} else if !exists {
+		return nil
+	}
+
+	lock := &Lockfile{}
+	if err := libbuildpack.NewYAML().Load(a.lockFilePath, lock); err != nil {
+		return fmt.Errorf("could not parse %s: %s", a.lockFilePath, err)
+	}
+
+	if !lock.matches(a.lockInputs()) {
+		a.logger.Warning("apt.lock does not match apt.yml, ignoring it and resolving packages again")
+		return nil
+	}
+
+	a.lock = lock
+	return nil
+}
+
+// WriteLock records every package resolved by DownloadAll to path.
+func (a *Apt) WriteLock(path string) error {
+	lock := a.lockInputs()
+	lock.Packages = append(append(make([]LockedPackage, 0, len(a.resolved)+len(a.resolvedBuild)), a.resolved...), a.resolvedBuild...)
+
+	if err := libbuildpack.NewYAML().Write(path, lock); err != nil {
+		return err
+	}
+
+	a.logger.Info("Wrote %d resolved packages to %s, commit it next to apt.yml as apt.lock to pin these versions", len(lock.Packages), path)
+	return nil
+}
+
+// lockInputs returns a Lockfile without packages that records the entries of
+// apt.yml deciding which packages get resolved.
+func (a *Apt) lockInputs() *Lockfile {
+	checksums := map[string]string{}
+	for _, pkg := range append(slices.Clone(a.Packages), a.BuildPackages...) {
+		if pkg.SHA256 != "" {
+			checksums[pkg.Name] = pkg.SHA256
+		}
+	}
+
+	return &Lockfile{
+		Requested:              requestedNames(a.Packages),
+		RequestedBuild:         requestedNames(a.BuildPackages),
+		SHA256:                 checksums,
+		Repos:                  a.Repos,
+		TruncateSources:        a.TruncateSources,
+		ReinstallStackPackages: a.ReinstallStackPackages,
+		Architectures:          a.AddedArchitectures,
+	}
+}
+
+// matches tells whether the lock was resolved from the same apt.yml entries as
+// inputs. Empty and missing lists are the same.
+func (l *Lockfile) matches(inputs *Lockfile) bool {
+	return slices.Equal(l.Requested, inputs.Requested) &&
+		slices.Equal(l.RequestedBuild, inputs.RequestedBuild) &&
+		maps.Equal(l.SHA256, inputs.SHA256) &&
+		(len(l.Repos) == 0 && len(inputs.Repos) == 0 || reflect.DeepEqual(l.Repos, inputs.Repos)) &&
+		l.TruncateSources == inputs.TruncateSources &&
+		l.ReinstallStackPackages == inputs.ReinstallStackPackages &&
+		slices.Equal(l.Architectures, inputs.Architectures)
+}
+
+func requestedNames(packages []Package) []string {
+	requested := make([]string, 0)
+	for _, pkg := range packages {
+		if pkg.Name != "" {
+			requested = append(requested, pkg.Name)
+		}
+	}
+	return requested
+}
+
+func (a *Apt) downloadLocked() error {
+	direct := map[string]bool{}
+	for _, pkg := range append(a.Packages, a.BuildPackages...) {
+		if pkg.IsURL() {
+			direct[pkg.Name] = true
+		}
+	}
+
+	repoPackages := make([]string, 0)
+	resolved := make([]LockedPackage, 0, len(a.lock.Packages))
+
+	for _, pkg := range a.lock.Packages {
+		if direct[pkg.Source] {
+			if err := a.download(Package{Name: pkg.Source, SHA256: pkg.SHA256}); err != nil {
+				return fmt.Errorf("could not download locked package %s %s from %s\n\n%s", pkg.Name, pkg.Version, pkg.Source, err)
+			}
+			pkg.file = filepath.Join(a.archiveDir, filepath.Base(pkg.Source))
+		} else {
+			repoPackages = append(repoPackages, pinnedName(pkg))
+			pkg.file = filepath.Join(a.archiveDir, archiveName(pkg.Name, pkg.Version, pkg.Architecture))
+		}
+		resolved = append(resolved, pkg)
+	}
+
+	if len(repoPackages) > 0 {
+		// the lock only lists packages the stack did not provide when it was
+		// written, so all of them are downloaded
+		aptArgs := append(a.options, "-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "-d", "install", "--reinstall")
+		args := append(aptArgs, repoPackages...)
+		out, err := a.command.Output("/", "apt-get", args...)
+		a.logger.Info("%s", out)
+		if err != nil {
+			return fmt.Errorf("failed to download the package versions pinned in apt.lock, the configured repos may no longer serve them (remove apt.lock to resolve again)\n\n%s\n\n%s", out, err)
+		}
+	}
+
+	a.resolved, a.resolvedBuild = nil, nil
+	for _, pkg := range resolved {
+		if err := verifySHA256(pkg.file, pkg.SHA256); err != nil {
+			return fmt.Errorf("could not verify locked package %s %s from %s: %s", pkg.Name, pkg.Version, pkg.Source, err)
+		}
+
+		if pkg.Build {
+			a.resolvedBuild = append(a.resolvedBuild, pkg)
+		} else {
+			a.resolved = append(a.resolved, pkg)
+		}
+	}
+
+	return nil
+}
+
+// resolve asks apt which archives it would fetch for the given packages.
+// An empty archives dir is used so that archives already in the cache are
+// listed as well.
+func (a *Apt) resolve(repoPackages []string) ([]LockedPackage, error) {
+	tmpDir, err := os.MkdirTemp("", "apt-resolve")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "partial"), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	aptArgs := append(a.options, "-o", "dir::cache::archives="+tmpDir, "-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "--print-uris")
+	args := append(append(aptArgs, a.installArgs()...), repoPackages...)
+	out, err := a.command.Output("/", "apt-get", args...)
+	if err != nil {
+		if unmet := unmetDependencies(out); len(unmet) > 0 {
+			return nil, fmt.Errorf("failed to resolve apt packages, unmet dependencies:\n%s\n\n%s", strings.Join(unmet, "\n"), err)
+		}
+		return nil, fmt.Errorf("failed to resolve apt packages %s\n\n%s", out, err)
+	}
+
+	resolved := make([]LockedPackage, 0)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "'") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "'file:") {
+			continue
+		}
+
+		name, version, arch, err := parseArchiveName(fields[1])
+		if err != nil {
+			return nil, err
+		}
+
+		resolved = append(resolved, LockedPackage{
+			Name:         name,
+			Version:      version,
+			Architecture: arch,
+			Source:       strings.Trim(fields[0], "'"),
+			file:         filepath.Join(a.archiveDir, fields[1]),
+		})
+	}
+
+	return resolved, scanner.Err()
+}
+
+// unmetDependencies picks the dependency lines apt-get prints after
+// "The following packages have unmet dependencies:".
+func unmetDependencies(out string) []string {
+	unmet := make([]string, 0)
+	for _, line := range strings.Split(out, "\n") {
+		if strings.Contains(line, "Depends:") {
+			unmet = append(unmet, strings.TrimSpace(line))
+		}
+	}
+	return unmet
+}
+
+func (a *Apt) debFields(file string) (map[string]string, error) {
+	fields, err := readControl(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read control fields of %s: %s", filepath.Base(file), err)
+	}
+	return fields, nil
+}
+
+// parseControl reads a single stanza in deb822 format, as used by control
+// files and the dpkg status database.
+func parseControl(content string) map[string]string {
+	fields := map[string]string{}
+	var last string
+
+	for _, line := range strings.Split(content, "\n") {
+		if line == "" {
+			continue
+		}
+
+		if (line[0] == ' ' || line[0] == '\t') && last != "" {
+			fields[last] += "\n" + strings.TrimSpace(line)
+			continue
+		}
+
+		if key, value, found := strings.Cut(line, ":"); found {
+			last = strings.TrimSpace(key)
+			fields[last] = strings.TrimSpace(value)
+		}
+	}
+
+	return fields
+}
+
+// archiveName matches the file name apt stores a downloaded package under.
+func archiveName(name, version, arch string) string {
+	escape := strings.NewReplacer(":", "%3a", "_", "%5f")
+	return escape.Replace(name) + "_" + escape.Replace(version) + "_" + escape.Replace(arch) + ".deb"
+}
+
+func parseArchiveName(file string) (string, string, string, error) {
+	parts := strings.Split(strings.TrimSuffix(file, ".deb"), "_")
+	if len(parts) != 3 {
+		return "", "", "", fmt.Errorf("unexpected archive name %s", file)
+	}
+
+	for i, part := range parts {
+		unescaped, err := url.PathUnescape(part)
+		if err != nil {
+			return "", "", "", fmt.Errorf("unexpected archive name %s: %s", file, err)
+		}
+		parts[i] = unescaped
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
+
+func pinnedName(pkg LockedPackage) string {
+	if pkg.Architecture == "" || pkg.Architecture == "all" {
+		return pkg.Name + "=" + pkg.Version
+	}
+	return pkg.Name + ":" + pkg.Architecture + "=" + pkg.Version
+}
+
+func verifySHA256(path, expected string) error {
+	actual, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("sha256 mismatch: expected %s, actual %s", expected, actual)
+	}
+	return nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
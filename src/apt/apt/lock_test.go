@@ -0,0 +1,211 @@
+package apt_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lockfile", func() {
+	var (
+		f          *aptFixture
+		a          *apt.Apt
+		aptOptions []interface{}
+	)
+
+	sha := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+
+	BeforeEach(func() {
+		f = newAptFixture()
+		f.writeAptYml(&apt.Apt{Packages: []apt.Package{{Name: "jq"}}})
+
+		aptOptions = []interface{}{
+			"-o", "debug::nolocking=true",
+			"-o", "dir::cache=" + f.cacheDir + "/apt/cache",
+			"-o", "dir::state=" + f.cacheDir + "/apt/state",
+			"-o", "dir::etc::sourcelist=" + f.cacheDir + "/apt/sources/sources.list",
+			"-o", "dir::etc::trusted=" + f.cacheDir + "/apt/etc/trusted.gpg",
+			"-o", "dir::etc::trustedparts=" + f.cacheDir + "/apt/etc/trusted.gpg.d",
+			"-o", "Dir::Etc::preferences=" + f.cacheDir + "/apt/etc/preferences",
+		}
+	})
+
+	JustBeforeEach(func() {
+		a = f.newApt()
+		Expect(a.Setup()).To(Succeed())
+	})
+
+	aptGet := func(args ...interface{}) *gomock.Call {
+		return f.mockCommand.EXPECT().Output("/", "apt-get", append(aptOptions[:len(aptOptions):len(aptOptions)], args...)...)
+	}
+
+	Context("without apt.lock", func() {
+		It("records every resolved package including dependencies", func() {
+			aptGet(
+				"-o", gomock.Any(),
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "--print-uris", "-d", "install", "jq",
+			).Return("Reading package lists...\n"+
+				"'http://archive.ubuntu.com/ubuntu/pool/main/j/jq/jq_1.6-2.1ubuntu3_amd64.deb' jq_1.6-2.1ubuntu3_amd64.deb 52000 SHA512:aaaa\n"+
+				"'http://archive.ubuntu.com/ubuntu/pool/main/libo/libonig/libonig5_1%3a6.9.7.1-2build1_amd64.deb' libonig5_1%3a6.9.7.1-2build1_amd64.deb 172000 SHA512:bbbb\n", nil)
+			aptGet(
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "-d", "install", "jq",
+			).DoAndReturn(func(string, string, ...string) (string, error) {
+				Expect(os.WriteFile(filepath.Join(f.archiveDir, "jq_1.6-2.1ubuntu3_amd64.deb"), []byte("jq"), 0644)).To(Succeed())
+				return "apt output", os.WriteFile(filepath.Join(f.archiveDir, "libonig5_1%3a6.9.7.1-2build1_amd64.deb"), []byte("onig"), 0644)
+			})
+
+			Expect(a.DownloadAll()).To(Succeed())
+
+			lockPath := filepath.Join(f.appDir, "generated.lock")
+			Expect(a.WriteLock(lockPath)).To(Succeed())
+
+			lock := apt.Lockfile{}
+			Expect(libbuildpack.NewYAML().Load(lockPath, &lock)).To(Succeed())
+			Expect(lock.Requested).To(Equal([]string{"jq"}))
+			Expect(lock.Packages).To(Equal([]apt.LockedPackage{
+				{Name: "jq", Version: "1.6-2.1ubuntu3", Architecture: "amd64", Source: "http://archive.ubuntu.com/ubuntu/pool/main/j/jq/jq_1.6-2.1ubuntu3_amd64.deb", SHA256: sha("jq")},
+				{Name: "libonig5", Version: "1:6.9.7.1-2build1", Architecture: "amd64", Source: "http://archive.ubuntu.com/ubuntu/pool/main/libo/libonig/libonig5_1%3a6.9.7.1-2build1_amd64.deb", SHA256: sha("onig")},
+			}))
+		})
+	})
+
+	Context("with apt.lock next to apt.yml", func() {
+		BeforeEach(func() {
+			lock := &apt.Lockfile{
+				Requested: []string{"jq"},
+				Packages: []apt.LockedPackage{
+					{Name: "jq", Version: "1.6-2.1ubuntu3", Architecture: "amd64", Source: "http://archive.ubuntu.com/jq.deb", SHA256: sha("jq")},
+					{Name: "libjq1", Version: "1:1.6", Architecture: "all", Source: "http://archive.ubuntu.com/libjq1.deb", SHA256: sha("libjq1")},
+				},
+			}
+			Expect(libbuildpack.NewYAML().Write(filepath.Join(f.appDir, "apt.lock"), lock)).To(Succeed())
+		})
+
+		It("downloads exactly the pinned versions", func() {
+			aptGet(
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "-d", "install", "--reinstall", "jq:amd64=1.6-2.1ubuntu3", "libjq1=1:1.6",
+			).DoAndReturn(func(string, string, ...string) (string, error) {
+				Expect(os.WriteFile(filepath.Join(f.archiveDir, "jq_1.6-2.1ubuntu3_amd64.deb"), []byte("jq"), 0644)).To(Succeed())
+				return "apt output", os.WriteFile(filepath.Join(f.archiveDir, "libjq1_1%3a1.6_all.deb"), []byte("libjq1"), 0644)
+			})
+
+			Expect(a.DownloadAll()).To(Succeed())
+			Expect(f.buffer.String()).To(ContainSubstring("Using package versions pinned in apt.lock"))
+		})
+
+		It("fails when a repo no longer serves a pinned version", func() {
+			aptGet(
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "-d", "install", "--reinstall", "jq:amd64=1.6-2.1ubuntu3", "libjq1=1:1.6",
+			).Return("E: Version '1.6-2.1ubuntu3' for 'jq' was not found", errors.New("exit status 100"))
+
+			err := a.DownloadAll()
+			Expect(err).To(MatchError(ContainSubstring("may no longer serve them")))
+			Expect(err).To(MatchError(ContainSubstring("Version '1.6-2.1ubuntu3' for 'jq' was not found")))
+		})
+
+		It("fails when a downloaded archive does not match its hash", func() {
+			aptGet(
+				"-y", "--allow-downgrades", "--allow-remove-essential", "--allow-change-held-packages", "-d", "install", "--reinstall", "jq:amd64=1.6-2.1ubuntu3", "libjq1=1:1.6",
+			).DoAndReturn(func(string, string, ...string) (string, error) {
+				Expect(os.WriteFile(filepath.Join(f.archiveDir, "jq_1.6-2.1ubuntu3_amd64.deb"), []byte("tampered"), 0644)).To(Succeed())
+				return "apt output", os.WriteFile(filepath.Join(f.archiveDir, "libjq1_1%3a1.6_all.deb"), []byte("libjq1"), 0644)
+			})
+
+			Expect(a.DownloadAll()).To(MatchError("could not verify locked package jq 1.6-2.1ubuntu3 from http://archive.ubuntu.com/jq.deb: sha256 mismatch: expected " + sha("jq") + ", actual " + sha("tampered")))
+		})
+
+		Context("when apt.yml lists different packages", func() {
+			BeforeEach(func() {
+				f.writeAptYml(&apt.Apt{Packages: []apt.Package{{Name: "jq"}, {Name: "curl"}}})
+			})
+
+			It("ignores the stale lock", func() {
+				Expect(f.buffer.String()).To(ContainSubstring("apt.lock does not match apt.yml"))
+			})
+		})
+
+		Context("when apt.yml lists different build packages", func() {
+			BeforeEach(func() {
+				f.writeAptYml(&apt.Apt{
+					Packages:      []apt.Package{{Name: "jq"}},
+					BuildPackages: []apt.Package{{Name: "libjq-dev"}},
+				})
+			})
+
+			It("ignores the stale lock", func() {
+				Expect(f.buffer.String()).To(ContainSubstring("apt.lock does not match apt.yml"))
+			})
+		})
+
+		Context("when apt.yml lists different repos", func() {
+			BeforeEach(func() {
+				f.writeAptYml(&apt.Apt{
+					Repos:    []apt.Repository{{Name: "deb http://apt.example.com stable main"}},
+					Packages: []apt.Package{{Name: "jq"}},
+				})
+			})
+
+			It("ignores the stale lock", func() {
+				Expect(f.buffer.String()).To(ContainSubstring("apt.lock does not match apt.yml"))
+			})
+		})
+
+		Context("when apt.yml changes reinstall_stack_packages", func() {
+			BeforeEach(func() {
+				f.writeAptYml(&apt.Apt{
+					ReinstallStackPackages: true,
+					Packages:               []apt.Package{{Name: "jq"}},
+				})
+			})
+
+			It("ignores the stale lock", func() {
+				Expect(f.buffer.String()).To(ContainSubstring("apt.lock does not match apt.yml"))
+			})
+		})
+
+		Context("when apt.yml adds architectures", func() {
+			BeforeEach(func() {
+				f.writeAptYml(&apt.Apt{
+					AddedArchitectures: []string{"i386"},
+					Packages:           []apt.Package{{Name: "jq"}},
+				})
+				f.mockCommand.EXPECT().Output("/", "dpkg", "--print-architecture").Return("amd64\n", nil).AnyTimes()
+				f.mockCommand.EXPECT().Output("/", "dpkg", "--print-foreign-architectures").Return("", nil).AnyTimes()
+			})
+
+			It("ignores the stale lock", func() {
+				Expect(f.buffer.String()).To(ContainSubstring("apt.lock does not match apt.yml"))
+			})
+		})
+
+		Context("when apt.yml pins a different sha256", func() {
+			BeforeEach(func() {
+				f.writeAptYml(&apt.Apt{
+					Packages: []apt.Package{{Name: "jq"}, {Name: "http://archive.ubuntu.com/libjq1.deb", SHA256: sha("libjq1")}},
+				})
+
+				lock := &apt.Lockfile{
+					Requested: []string{"jq", "http://archive.ubuntu.com/libjq1.deb"},
+					SHA256:    map[string]string{"http://archive.ubuntu.com/libjq1.deb": sha("other")},
+				}
+				Expect(libbuildpack.NewYAML().Write(filepath.Join(f.appDir, "apt.lock"), lock)).To(Succeed())
+			})
+
+			It("ignores the stale lock", func() {
+				Expect(f.buffer.String()).To(ContainSubstring("apt.lock does not match apt.yml"))
+			})
+		})
+	})
+})
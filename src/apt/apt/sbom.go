@@ -0,0 +1,214 @@
+package apt
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sbomPackage is what both SBOM formats record about a resolved package.
+type sbomPackage struct {
+	LockedPackage
+	Repository string
+	Licenses   []string
+	PURL       string
+}
+
+// WriteSBOM writes CycloneDX and SPDX documents describing every package
+// resolved for this staging, including dependencies and direct .debs, to dir.
+func (a *Apt) WriteSBOM(dir string) error {
+	distro := a.distro()
+
+	packages := make([]sbomPackage, 0, len(a.resolved))
+	for _, pkg := range a.resolved {
+		packages = append(packages, sbomPackage{
+			LockedPackage: pkg,
+			Repository:    repositoryOf(pkg.Source),
+			Licenses:      a.packageLicenses(pkg.Name),
+			PURL:          purl(distro, pkg),
+		})
+	}
+
+	serial, err := uuid()
+	if err != nil {
+		return err
+	}
+	created := time.Now().UTC().Format(time.RFC3339)
+
+	cdxPath := filepath.Join(dir, "sbom.cdx.json")
+	if err := writeJSON(cdxPath, cycloneDX(packages, serial, created)); err != nil {
+		return fmt.Errorf("could not write CycloneDX SBOM: %s", err)
+	}
+
+	spdxPath := filepath.Join(dir, "sbom.spdx.json")
+	if err := writeJSON(spdxPath, spdx(packages, serial, created)); err != nil {
+		return fmt.Errorf("could not write SPDX SBOM: %s", err)
+	}
+
+	a.logger.Info("Wrote SBOM of %d packages to %s and %s", len(packages), cdxPath, spdxPath)
+	return nil
+}
+
+func cycloneDX(packages []sbomPackage, serial, created string) map[string]interface{} {
+	components := make([]map[string]interface{}, 0, len(packages))
+	for _, pkg := range packages {
+		licenses := make([]map[string]interface{}, 0, len(pkg.Licenses))
+		for _, license := range pkg.Licenses {
+			licenses = append(licenses, map[string]interface{}{"license": map[string]string{"name": license}})
+		}
+
+		properties := []map[string]string{{"name": "apt:architecture", "value": pkg.Architecture}}
+		if pkg.Repository != "" {
+			properties = append(properties, map[string]string{"name": "apt:repository", "value": pkg.Repository})
+		}
+
+		component := map[string]interface{}{
+			"type":       "library",
+			"bom-ref":    pkg.PURL,
+			"name":       pkg.Name,
+			"version":    pkg.Version,
+			"purl":       pkg.PURL,
+			"hashes":     []map[string]string{{"alg": "SHA-256", "content": pkg.SHA256}},
+			"properties": properties,
+			"externalReferences": []map[string]string{
+				{"type": "distribution", "url": pkg.Source},
+			},
+		}
+		if len(licenses) > 0 {
+			component["licenses"] = licenses
+		}
+		components = append(components, component)
+	}
+
+	return map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + serial,
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": created,
+			"tools": map[string]interface{}{
+				"components": []map[string]string{{"type": "application", "name": "apt-buildpack"}},
+			},
+		},
+		"components": components,
+	}
+}
+
+func spdx(packages []sbomPackage, serial, created string) map[string]interface{} {
+	spdxPackages := make([]map[string]interface{}, 0, len(packages))
+	relationships := make([]map[string]string, 0, len(packages))
+
+	for i, pkg := range packages {
+		id := fmt.Sprintf("SPDXRef-Package-%d-%s", i, spdxIDPart.ReplaceAllString(pkg.Name, "-"))
+
+		// Debian license names are not SPDX identifiers, so they are only
+		// recorded as a comment
+		comment := "architecture: " + pkg.Architecture
+		if pkg.Repository != "" {
+			comment += ", repository: " + pkg.Repository
+		}
+		if len(pkg.Licenses) > 0 {
+			comment += ", licenses: " + strings.Join(pkg.Licenses, ", ")
+		}
+
+		spdxPackages = append(spdxPackages, map[string]interface{}{
+			"SPDXID":           id,
+			"name":             pkg.Name,
+			"versionInfo":      pkg.Version,
+			"downloadLocation": pkg.Source,
+			"filesAnalyzed":    false,
+			"checksums":        []map[string]string{{"algorithm": "SHA256", "checksumValue": pkg.SHA256}},
+			"licenseConcluded": "NOASSERTION",
+			"licenseDeclared":  "NOASSERTION",
+			"copyrightText":    "NOASSERTION",
+			"comment":          comment,
+			"externalRefs": []map[string]string{
+				{"referenceCategory": "PACKAGE-MANAGER", "referenceType": "purl", "referenceLocator": pkg.PURL},
+			},
+		})
+		relationships = append(relationships, map[string]string{
+			"spdxElementId":      "SPDXRef-DOCUMENT",
+			"relationshipType":   "DESCRIBES",
+			"relatedSpdxElement": id,
+		})
+	}
+
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              "apt-buildpack",
+		"documentNamespace": "https://cloudfoundry.org/apt-buildpack/spdx/" + serial,
+		"creationInfo": map[string]interface{}{
+			"created":  created,
+			"creators": []string{"Tool: apt-buildpack"},
+		},
+		"packages":      spdxPackages,
+		"relationships": relationships,
+	}
+}
+
+var spdxIDPart = regexp.MustCompile(`[^A-Za-z0-9.]+`)
+
+// repositoryOf returns the archive root of a package downloaded from an apt
+// repo, or an empty string for a .deb given by URL.
+func repositoryOf(source string) string {
+	if root, _, found := strings.Cut(source, "/pool/"); found {
+		return root
+	}
+	return ""
+}
+
+func purl(distro string, pkg LockedPackage) string {
+	return fmt.Sprintf("pkg:deb/%s/%s@%s?arch=%s", distro, url.PathEscape(pkg.Name), url.PathEscape(pkg.Version), url.QueryEscape(pkg.Architecture))
+}
+
+// distro is the ID from the stack's os-release, which names the purl
+// namespace of its packages.
+func (a *Apt) distro() string {
+	if id := a.osRelease("ID"); id != "" {
+		return id
+	}
+	return "debian"
+}
+
+// osRelease returns a field of the stack's os-release, or an empty string
+// when it has none.
+func (a *Apt) osRelease(field string) string {
+	data, err := os.ReadFile(filepath.Join(filepath.Dir(a.rootDir), "os-release"))
+	if err != nil {
+		return ""
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, found := strings.CutPrefix(line, field+"="); found {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
+
+func uuid() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,133 @@
+package apt_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("WriteSBOM", func() {
+	var (
+		f         *aptFixture
+		a         *apt.Apt
+		server    *ghttp.Server
+		directDeb []byte
+		cdx       map[string]interface{}
+		spdx      map[string]interface{}
+	)
+
+	sha := func(content []byte) string {
+		sum := sha256.Sum256(content)
+		return hex.EncodeToString(sum[:])
+	}
+
+	copyright := "Format: https://www.debian.org/doc/packaging-manuals/copyright-format/1.0/\n" +
+		"Upstream-Name: libfoo\n\n" +
+		"Files: *\nCopyright: 2024 Foo\nLicense: MIT\n\n" +
+		"Files: debian/*\nCopyright: 2024 Debian\nLicense: GPL-2+\n This package is free software.\n\n" +
+		"License: MIT\n Permission is hereby granted.\n"
+
+	BeforeEach(func() {
+		f = newAptFixture()
+		directDeb = debArchive("Package: direct\nVersion: 2.0\nArchitecture: all\n", "xz", []debEntry{{Name: "./usr/bin/direct", Body: "direct"}})
+
+		server = ghttp.NewServer()
+		server.AppendHandlers(ghttp.RespondWith(http.StatusOK, directDeb))
+
+		f.writeAptYml(&apt.Apt{Packages: []apt.Package{
+			{Name: "libfoo1"},
+			{Name: server.URL() + "/direct.deb"},
+		}})
+
+		writeDeb(filepath.Join(f.archiveDir, "libfoo1_1%3a1.2-3_amd64.deb"), "Package: libfoo1\n", "gz", []debEntry{
+			{Name: "./usr/share/doc/libfoo1/copyright", Body: copyright},
+		})
+
+		DeferCleanup(func() {
+			server.Close()
+		})
+	})
+
+	JustBeforeEach(func() {
+		a = f.newApt()
+		Expect(a.Setup()).To(Succeed())
+
+		resolveArchives(f.mockCommand, a, "libfoo1_1%3a1.2-3_amd64.deb")
+		Expect(a.InstallAll()).To(Succeed())
+
+		sbomDir := filepath.Join(f.cacheDir, "sbom")
+		Expect(os.MkdirAll(sbomDir, 0755)).To(Succeed())
+		Expect(a.WriteSBOM(sbomDir)).To(Succeed())
+
+		for path, doc := range map[string]*map[string]interface{}{"sbom.cdx.json": &cdx, "sbom.spdx.json": &spdx} {
+			data, err := os.ReadFile(filepath.Join(sbomDir, path))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Unmarshal(data, doc)).To(Succeed())
+		}
+	})
+
+	It("describes every resolved package in a CycloneDX document", func() {
+		Expect(cdx["bomFormat"]).To(Equal("CycloneDX"))
+		Expect(cdx["serialNumber"]).To(MatchRegexp(`^urn:uuid:[0-9a-f-]{36}$`))
+
+		components := cdx["components"].([]interface{})
+		Expect(components).To(HaveLen(2))
+
+		direct := components[0].(map[string]interface{})
+		Expect(direct["name"]).To(Equal("direct"))
+		Expect(direct["version"]).To(Equal("2.0"))
+		Expect(direct["purl"]).To(Equal("pkg:deb/debian/direct@2.0?arch=all"))
+		Expect(direct["externalReferences"]).To(ConsistOf(map[string]interface{}{"type": "distribution", "url": server.URL() + "/direct.deb"}))
+		Expect(direct["hashes"]).To(ConsistOf(map[string]interface{}{
+			"alg":     "SHA-256",
+			"content": sha(directDeb),
+		}))
+		Expect(direct).NotTo(HaveKey("licenses"))
+
+		libfoo := components[1].(map[string]interface{})
+		Expect(libfoo["name"]).To(Equal("libfoo1"))
+		Expect(libfoo["version"]).To(Equal("1:1.2-3"))
+		Expect(libfoo["purl"]).To(Equal("pkg:deb/debian/libfoo1@1:1.2-3?arch=amd64"))
+		Expect(libfoo["properties"]).To(ConsistOf(
+			map[string]interface{}{"name": "apt:architecture", "value": "amd64"},
+			map[string]interface{}{"name": "apt:repository", "value": "http://example.com"},
+		))
+		Expect(libfoo["licenses"]).To(ConsistOf(
+			map[string]interface{}{"license": map[string]interface{}{"name": "MIT"}},
+			map[string]interface{}{"license": map[string]interface{}{"name": "GPL-2+"}},
+		))
+	})
+
+	It("describes every resolved package in an SPDX document", func() {
+		Expect(spdx["spdxVersion"]).To(Equal("SPDX-2.3"))
+
+		packages := spdx["packages"].([]interface{})
+		Expect(packages).To(HaveLen(2))
+
+		libfoo := packages[1].(map[string]interface{})
+		Expect(libfoo["name"]).To(Equal("libfoo1"))
+		Expect(libfoo["versionInfo"]).To(Equal("1:1.2-3"))
+		Expect(libfoo["downloadLocation"]).To(Equal("http://example.com/pool/libfoo1_1%3a1.2-3_amd64.deb"))
+		Expect(libfoo["comment"]).To(Equal("architecture: amd64, repository: http://example.com, licenses: MIT, GPL-2+"))
+		Expect(libfoo["externalRefs"]).To(ConsistOf(map[string]interface{}{
+			"referenceCategory": "PACKAGE-MANAGER",
+			"referenceType":     "purl",
+			"referenceLocator":  "pkg:deb/debian/libfoo1@1:1.2-3?arch=amd64",
+		}))
+
+		Expect(spdx["relationships"]).To(HaveLen(2))
+	})
+
+	It("logs where the documents were written", func() {
+		Expect(f.buffer.String()).To(ContainSubstring("Wrote SBOM of 2 packages to"))
+	})
+})
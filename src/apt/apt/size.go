@@ -0,0 +1,113 @@
+package apt
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// largestPackages is how many packages the error for an exceeded max_size
+// lists.
+const largestPackages = 5
+
+var sizeUnits = map[string]int64{
+	"":  1,
+	"K": 1 << 10,
+	"M": 1 << 20,
+	"G": 1 << 30,
+}
+
+// packageSize is the space a package installed by InstallAll takes in the
+// droplet.
+type packageSize struct {
+	name    string
+	version string
+	size    int64
+}
+
+// ReportSizes logs the space each package installed into the droplet takes,
+// largest first, and fails when together they take more than the max_size of
+// apt.yml.
+func (a *Apt) ReportSizes() error {
+	if len(a.installedSizes) == 0 {
+		return nil
+	}
+
+	sizes := slices.Clone(a.installedSizes)
+	sort.SliceStable(sizes, func(i, j int) bool { return sizes[i].size > sizes[j].size })
+
+	width := len("Total")
+	var total int64
+	for _, pkg := range sizes {
+		width = max(width, len(pkg.name)+1+len(pkg.version))
+		total += pkg.size
+	}
+
+	a.logger.Info("Installed size of %d packages:", len(sizes))
+	for _, pkg := range sizes {
+		a.logger.Info("  %-*s %10s", width, pkg.name+" "+pkg.version, formatSize(pkg.size))
+	}
+	a.logger.Info("  %-*s %10s", width, "Total", formatSize(total))
+
+	maxSize, _ := parseSize(a.MaxSize)
+	if maxSize == 0 || total <= maxSize {
+		return nil
+	}
+
+	var largest []string
+	for _, pkg := range sizes[:min(largestPackages, len(sizes))] {
+		largest = append(largest, fmt.Sprintf("%s %s: %s", pkg.name, pkg.version, formatSize(pkg.size)))
+	}
+	return fmt.Errorf("installed packages take %s, more than max_size %s in apt.yml, the largest are:\n%s", formatSize(total), a.MaxSize, strings.Join(largest, "\n"))
+}
+
+// recordSizes keeps the size of each of the packages installed into the
+// droplet for ReportSizes.
+func (a *Apt) recordSizes(packages []LockedPackage, installed []*debContents) {
+	a.installedSizes = make([]packageSize, len(packages))
+	for i, pkg := range packages {
+		name := pkg.Name
+		if len(a.architectures) > 1 && slices.Contains(a.architectures[1:], pkg.Architecture) {
+			name += ":" + pkg.Architecture
+		}
+		a.installedSizes[i] = packageSize{name: name, version: pkg.Version, size: installed[i].Size}
+	}
+}
+
+// parseSize reads a size such as 512M or 1.5G, in bytes when it has no unit.
+// Units are powers of 1024, as for the disk quota of an app.
+func parseSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+
+	number := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+	unit := ""
+	if number != "" {
+		if _, found := sizeUnits[number[len(number)-1:]]; found {
+			unit = number[len(number)-1:]
+			number = number[:len(number)-1]
+		}
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("max_size %s is not a size such as 512M or 2G", size)
+	}
+	return int64(value * float64(sizeUnits[unit])), nil
+}
+
+func formatSize(size int64) string {
+	switch {
+	case size >= sizeUnits["G"]:
+		return fmt.Sprintf("%.1fG", float64(size)/float64(sizeUnits["G"]))
+	case size >= sizeUnits["M"]:
+		return fmt.Sprintf("%.1fM", float64(size)/float64(sizeUnits["M"]))
+	case size >= sizeUnits["K"]:
+		return fmt.Sprintf("%.1fK", float64(size)/float64(sizeUnits["K"]))
+	default:
+		return fmt.Sprintf("%dB", size)
+	}
+}
@@ -0,0 +1,91 @@
+package apt_test
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Installed sizes", func() {
+	var (
+		f       *aptFixture
+		a       *apt.Apt
+		maxSize string
+	)
+
+	BeforeEach(func() {
+		f = newAptFixture()
+		maxSize = ""
+
+		writeDeb(filepath.Join(f.archiveDir, "imagemagick_6.9_amd64.deb"), "Package: imagemagick\nVersion: 6.9\nArchitecture: amd64\n", "gz", []debEntry{
+			{Name: "./usr/bin/convert", Body: strings.Repeat("x", 3000), Mode: 0755},
+		})
+		writeDeb(filepath.Join(f.archiveDir, "libmagickcore_6.9_amd64.deb"), "Package: libmagickcore\nVersion: 6.9\nArchitecture: amd64\n", "gz", []debEntry{
+			{Name: "./usr/lib/libMagickCore.so", Body: strings.Repeat("x", 2048)},
+			{Name: "./usr/lib/libMagickCore.so.6", Body: strings.Repeat("x", 2048)},
+		})
+		writeDeb(filepath.Join(f.archiveDir, "libgomp1_12_amd64.deb"), "Package: libgomp1\nVersion: 12\nArchitecture: amd64\n", "gz", []debEntry{
+			{Name: "./usr/lib/libgomp.so.1", Body: strings.Repeat("x", 100)},
+		})
+	})
+
+	JustBeforeEach(func() {
+		f.writeAptYml(&apt.Apt{
+			Packages: []apt.Package{{Name: "imagemagick"}},
+			MaxSize:  maxSize,
+		})
+		a = f.newApt()
+	})
+
+	Context("with a valid max_size", func() {
+		JustBeforeEach(func() {
+			Expect(a.Setup()).To(Succeed())
+			resolveArchives(f.mockCommand, a, "imagemagick_6.9_amd64.deb", "libgomp1_12_amd64.deb", "libmagickcore_6.9_amd64.deb")
+			Expect(a.InstallAll()).To(Succeed())
+		})
+
+		It("logs the size of every installed package, largest first", func() {
+			Expect(a.ReportSizes()).To(Succeed())
+
+			Expect(f.buffer.String()).To(ContainSubstring("Installed size of 3 packages:"))
+			Expect(f.buffer.String()).To(MatchRegexp(`libmagickcore 6\.9 +4\.0K\n.*imagemagick 6\.9 +2\.9K\n.*libgomp1 12 +100B\n.*Total +7\.0K\n`))
+		})
+
+		Context("when the packages take more than max_size", func() {
+			BeforeEach(func() {
+				maxSize = "6K"
+			})
+
+			It("fails listing the largest packages", func() {
+				Expect(a.ReportSizes()).To(MatchError("installed packages take 7.0K, more than max_size 6K in apt.yml, the largest are:\n" +
+					"libmagickcore 6.9: 4.0K\n" +
+					"imagemagick 6.9: 2.9K\n" +
+					"libgomp1 12: 100B"))
+			})
+		})
+
+		Context("when the packages fit into max_size", func() {
+			BeforeEach(func() {
+				maxSize = "1.5MB"
+			})
+
+			It("succeeds", func() {
+				Expect(a.ReportSizes()).To(Succeed())
+			})
+		})
+	})
+
+	Context("when max_size is not a size", func() {
+		BeforeEach(func() {
+			maxSize = "lots"
+		})
+
+		It("fails to set up", func() {
+			Expect(a.Setup()).To(MatchError("max_size lots is not a size such as 512M or 2G"))
+		})
+	})
+})
@@ -0,0 +1,120 @@
+package apt
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// StatusDir is the dpkg admindir recording the packages unpacked into the
+// install dir, usable with dpkg-query --admindir.
+func (a *Apt) StatusDir() string {
+	return filepath.Join(a.installDir, "var", "lib", "dpkg")
+}
+
+// writeStatus records installed packages in a dpkg style status file, next
+// to a file list (and md5sums) per package. Entries for packages recorded by
+// an earlier install are kept unless the package was installed again.
+func (a *Apt) writeStatus(installed []*debContents) error {
+	statusDir := a.StatusDir()
+	infoDir := filepath.Join(statusDir, "info")
+
+	for _, dir := range []string{infoDir, filepath.Join(statusDir, "updates")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	// format 1 tells dpkg that Multi-Arch: same file lists are arch qualified
+	if err := os.WriteFile(filepath.Join(infoDir, "format"), []byte("1\n"), 0644); err != nil {
+		return err
+	}
+
+	entries, err := readStatus(filepath.Join(statusDir, "status"))
+	if err != nil {
+		return fmt.Errorf("could not read dpkg status: %s", err)
+	}
+
+	for _, contents := range installed {
+		fields := parseControl(contents.Control)
+		if fields["Package"] == "" {
+			return fmt.Errorf("could not record dpkg status: package without a control file")
+		}
+		entries[statusKey(fields)] = statusEntry(contents.Control)
+
+		infoName := fields["Package"]
+		if fields["Multi-Arch"] == "same" {
+			infoName += ":" + fields["Architecture"]
+		}
+
+		list := strings.Join(contents.Files, "\n") + "\n"
+		if err := os.WriteFile(filepath.Join(infoDir, infoName+".list"), []byte(list), 0644); err != nil {
+			return err
+		}
+		if contents.MD5Sums != "" {
+			if err := os.WriteFile(filepath.Join(infoDir, infoName+".md5sums"), []byte(contents.MD5Sums), 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var status strings.Builder
+	for _, key := range keys {
+		status.WriteString(entries[key])
+		status.WriteString("\n\n")
+	}
+
+	return os.WriteFile(filepath.Join(statusDir, "status"), []byte(status.String()), 0644)
+}
+
+// readStatus returns the paragraphs of an existing status file keyed by
+// package and architecture.
+func readStatus(path string) (map[string]string, error) {
+	entries := map[string]string{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, paragraph := range strings.Split(string(data), "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		entries[statusKey(parseControl(paragraph))] = paragraph
+	}
+
+	return entries, nil
+}
+
+func statusKey(fields map[string]string) string {
+	return fields["Package"] + ":" + fields["Architecture"]
+}
+
+// statusEntry turns a control file into a status paragraph by adding the
+// Status field after Package, where dpkg puts it.
+func statusEntry(control string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(control), "\n") {
+		if strings.HasPrefix(line, "Status:") {
+			continue
+		}
+		lines = append(lines, line)
+		if strings.HasPrefix(line, "Package:") {
+			lines = append(lines, "Status: install ok installed")
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,95 @@
+package apt_test
+
+import (
+	"archive/tar"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dpkg status", func() {
+	var (
+		f         *aptFixture
+		a         *apt.Apt
+		statusDir string
+	)
+
+	BeforeEach(func() {
+		f = newAptFixture()
+		statusDir = filepath.Join(f.installDir, "var", "lib", "dpkg")
+
+		f.writeAptYml(&apt.Apt{Packages: []apt.Package{{Name: "foo"}, {Name: "libbar1"}}})
+
+		writeDeb(filepath.Join(f.archiveDir, "foo_1.0_amd64.deb"),
+			"Package: foo\nVersion: 1.0\nArchitecture: amd64\nMaintainer: Foo <foo@example.com>\nDescription: foo\n the foo tool\n",
+			"xz", []debEntry{
+				{Name: "./", Type: tar.TypeDir, Mode: 0755},
+				{Name: "./usr/", Type: tar.TypeDir, Mode: 0755},
+				{Name: "./usr/bin/", Type: tar.TypeDir, Mode: 0755},
+				{Name: "./usr/bin/foo", Body: "foo", Mode: 0755},
+			})
+		writeDeb(filepath.Join(f.archiveDir, "libbar1_2.0_amd64.deb"),
+			"Package: libbar1\nVersion: 2.0\nArchitecture: amd64\nMulti-Arch: same\nMaintainer: Bar <bar@example.com>\nDescription: bar\n",
+			"gz", []debEntry{
+				{Name: "./usr/lib/x86_64-linux-gnu/libbar.so.1", Body: "bar"},
+			})
+	})
+
+	JustBeforeEach(func() {
+		a = f.newApt()
+		Expect(a.Setup()).To(Succeed())
+		resolveArchives(f.mockCommand, a, "foo_1.0_amd64.deb", "libbar1_2.0_amd64.deb")
+	})
+
+	It("records installed packages in a status file", func() {
+		Expect(a.InstallAll()).To(Succeed())
+		Expect(a.StatusDir()).To(Equal(statusDir))
+
+		status, err := os.ReadFile(filepath.Join(statusDir, "status"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(status)).To(Equal(
+			"Package: foo\nStatus: install ok installed\nVersion: 1.0\nArchitecture: amd64\nMaintainer: Foo <foo@example.com>\nDescription: foo\n the foo tool\n\n" +
+				"Package: libbar1\nStatus: install ok installed\nVersion: 2.0\nArchitecture: amd64\nMulti-Arch: same\nMaintainer: Bar <bar@example.com>\nDescription: bar\n\n"))
+	})
+
+	It("lists the files of each package", func() {
+		Expect(a.InstallAll()).To(Succeed())
+
+		Expect(os.ReadFile(filepath.Join(statusDir, "info", "foo.list"))).To(Equal([]byte("/.\n/usr\n/usr/bin\n/usr/bin/foo\n")))
+		Expect(os.ReadFile(filepath.Join(statusDir, "info", "libbar1:amd64.list"))).To(Equal([]byte("/usr/lib/x86_64-linux-gnu/libbar.so.1\n")))
+	})
+
+	It("keeps packages recorded by an earlier install", func() {
+		Expect(os.MkdirAll(statusDir, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(statusDir, "status"), []byte(
+			"Package: foo\nStatus: install ok installed\nVersion: 0.9\nArchitecture: amd64\n\n"+
+				"Package: baz\nStatus: install ok installed\nVersion: 3.0\nArchitecture: all\n\n"), 0644)).To(Succeed())
+
+		Expect(a.InstallAll()).To(Succeed())
+
+		status, err := os.ReadFile(filepath.Join(statusDir, "status"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(status)).To(HavePrefix("Package: baz\nStatus: install ok installed\nVersion: 3.0\nArchitecture: all\n\nPackage: foo\nStatus: install ok installed\nVersion: 1.0\n"))
+		Expect(string(status)).NotTo(ContainSubstring("0.9"))
+	})
+
+	It("can be queried with dpkg-query", func() {
+		if _, err := exec.LookPath("dpkg-query"); err != nil {
+			Skip("dpkg-query is not available")
+		}
+		Expect(a.InstallAll()).To(Succeed())
+
+		output, err := exec.Command("dpkg-query", "--admindir", statusDir, "-W", "-f", "${Package} ${Version}\n").CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(output))
+		Expect(string(output)).To(Equal("foo 1.0\nlibbar1 2.0\n"))
+
+		output, err = exec.Command("dpkg-query", "--admindir", statusDir, "-S", "/usr/bin/foo").CombinedOutput()
+		Expect(err).NotTo(HaveOccurred(), string(output))
+		Expect(string(output)).To(Equal("foo: /usr/bin/foo\n"))
+	})
+})
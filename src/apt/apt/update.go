@@ -0,0 +1,114 @@
+package apt
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+const (
+	updateAlways = "always"
+	updateNever  = "never"
+)
+
+// updateStamp records when apt-get update last succeeded, and the digest of
+// what it read, so that a later staging can skip it.
+type updateStamp struct {
+	Updated time.Time `yaml:"updated"`
+	Inputs  string    `yaml:"inputs"`
+}
+
+// updateMaxAge reads the update policy of apt.yml, which is always (the
+// default), never or how old the package lists may get, such as 6h. It
+// returns zero for always and a negative age for never.
+func (a *Apt) updateMaxAge() (time.Duration, error) {
+	switch a.UpdatePolicy {
+	case "", updateAlways:
+		return 0, nil
+	case updateNever:
+		return -1, nil
+	}
+
+	age, err := time.ParseDuration(a.UpdatePolicy)
+	if err != nil || age <= 0 {
+		return 0, fmt.Errorf("update %s in apt.yml is not always, never or a duration such as 6h", a.UpdatePolicy)
+	}
+	return age, nil
+}
+
+// Update runs apt-get update, unless the update policy of apt.yml allows
+// the package lists of an earlier staging to be used: they are new enough
+// and sources.list, keys, preferences and options are unchanged.
+func (a *Apt) Update() error {
+	maxAge, err := a.updateMaxAge()
+	if err != nil {
+		return err
+	}
+
+	inputs, err := a.updateInputs()
+	if err != nil {
+		return fmt.Errorf("could not read apt sources\n\n%s", err)
+	}
+
+	stampPath := filepath.Join(a.stateDir, "buildpack_update.yml")
+	if maxAge != 0 {
+		reason := a.updateReason(stampPath, inputs, maxAge)
+		if reason == "" {
+			return nil
+		}
+		a.logger.Info("Updating package lists, %s", reason)
+	}
+
+	// a failed update may leave the lists half written
+	if err := os.Remove(stampPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	args := append(a.options, "update")
+
+	var errBuff bytes.Buffer
+	if err := a.command.Execute("/", &errBuff, &errBuff, "apt-get", args...); err != nil {
+		return fmt.Errorf("failed to apt-get update %s\n\n%s", errBuff.String(), err)
+	}
+
+	return libbuildpack.NewYAML().Write(stampPath, updateStamp{Updated: time.Now().UTC(), Inputs: inputs})
+}
+
+// updateReason tells why the package lists need to be updated, or returns
+// an empty string after logging why they do not.
+func (a *Apt) updateReason(stampPath, inputs string, maxAge time.Duration) string {
+	var stamp updateStamp
+	if exists, err := libbuildpack.FileExists(stampPath); err != nil || !exists {
+		return "there are none from an earlier staging"
+	}
+	if err := libbuildpack.NewYAML().Load(stampPath, &stamp); err != nil {
+		return "the record of the last update is unreadable"
+	}
+
+	if stamp.Inputs != inputs {
+		return "sources.list, keys or preferences changed"
+	}
+
+	age := time.Since(stamp.Updated)
+	if maxAge > 0 && age > maxAge {
+		return fmt.Sprintf("they are older than %s", a.UpdatePolicy)
+	}
+
+	a.logger.Info("Skipping apt-get update, the package lists are from %s and sources.list, keys and preferences are unchanged", stamp.Updated.Format(time.RFC3339))
+	return ""
+}
+
+// updateInputs digests what apt-get update reads besides the repos: the
+// options, sources.list, keys and preferences.
+func (a *Apt) updateInputs() (string, error) {
+	keys, err := dirFiles(a.trustedParts, a.keyrings)
+	if err != nil {
+		return "", err
+	}
+	return digest([]string{"options " + strings.Join(a.options, " ")}, append([]string{a.sourceList, a.trustedKeys, a.preferences}, keys...))
+}
@@ -0,0 +1,138 @@
+package apt_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/apt"
+
+	"github.com/golang/mock/gomock"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Update policy", func() {
+	var (
+		f      *aptFixture
+		policy string
+	)
+
+	// stage sets up apt as a new staging with the same cache dir would
+	stage := func() *apt.Apt {
+		f.writeAptYml(&apt.Apt{
+			Packages:     []apt.Package{{Name: "curl"}},
+			UpdatePolicy: policy,
+		})
+		a := f.newApt()
+		Expect(a.Setup()).To(Succeed())
+		return a
+	}
+
+	expectUpdate := func() {
+		f.mockCommand.EXPECT().Execute("/", gomock.Any(), gomock.Any(), "apt-get", gomock.Any()).DoAndReturn(func(_ string, _, _ interface{}, _ string, args ...string) error {
+			Expect(args[len(args)-1]).To(Equal("update"))
+			return nil
+		})
+	}
+
+	BeforeEach(func() {
+		f = newAptFixture()
+		policy = "6h"
+
+		f.writeSourcesList("deb http://archive.ubuntu.com/ubuntu jammy main\n")
+	})
+
+	It("updates when there are no package lists from an earlier staging", func() {
+		expectUpdate()
+		Expect(stage().Update()).To(Succeed())
+		Expect(f.buffer.String()).To(ContainSubstring("Updating package lists, there are none from an earlier staging"))
+	})
+
+	It("skips the update when the lists are fresh and the inputs unchanged", func() {
+		expectUpdate()
+		Expect(stage().Update()).To(Succeed())
+
+		Expect(stage().Update()).To(Succeed())
+		Expect(f.buffer.String()).To(ContainSubstring("Skipping apt-get update, the package lists are from"))
+	})
+
+	It("updates when the keys changed", func() {
+		expectUpdate()
+		Expect(stage().Update()).To(Succeed())
+
+		Expect(os.WriteFile(filepath.Join(f.cacheDir, "apt", "etc", "trusted.gpg.d", "example.gpg"), []byte("key"), 0644)).To(Succeed())
+		expectUpdate()
+		Expect(stage().Update()).To(Succeed())
+		Expect(f.buffer.String()).To(ContainSubstring("Updating package lists, sources.list, keys or preferences changed"))
+	})
+
+	Context("when the lists may only be a moment old", func() {
+		BeforeEach(func() {
+			policy = "1ns"
+		})
+
+		It("updates every time", func() {
+			expectUpdate()
+			Expect(stage().Update()).To(Succeed())
+
+			expectUpdate()
+			Expect(stage().Update()).To(Succeed())
+			Expect(f.buffer.String()).To(ContainSubstring("Updating package lists, they are older than 1ns"))
+		})
+	})
+
+	Context("when the policy is never", func() {
+		BeforeEach(func() {
+			policy = "never"
+		})
+
+		It("only updates for the first staging", func() {
+			expectUpdate()
+			Expect(stage().Update()).To(Succeed())
+			Expect(stage().Update()).To(Succeed())
+		})
+	})
+
+	Context("when the policy is always", func() {
+		BeforeEach(func() {
+			policy = "always"
+		})
+
+		It("updates every time", func() {
+			expectUpdate()
+			Expect(stage().Update()).To(Succeed())
+
+			expectUpdate()
+			Expect(stage().Update()).To(Succeed())
+			Expect(f.buffer.String()).NotTo(ContainSubstring("Skipping apt-get update"))
+		})
+	})
+
+	Context("when the update fails", func() {
+		It("updates again for the next staging", func() {
+			expectUpdate()
+			Expect(stage().Update()).To(Succeed())
+
+			key := filepath.Join(f.cacheDir, "apt", "etc", "trusted.gpg.d", "example.gpg")
+			Expect(os.WriteFile(key, []byte("key"), 0644)).To(Succeed())
+			f.mockCommand.EXPECT().Execute("/", gomock.Any(), gomock.Any(), "apt-get", gomock.Any()).Return(os.ErrDeadlineExceeded)
+			Expect(stage().Update()).NotTo(Succeed())
+
+			Expect(os.Remove(key)).To(Succeed())
+			expectUpdate()
+			Expect(stage().Update()).To(Succeed())
+		})
+	})
+
+	Context("when the policy is not valid", func() {
+		BeforeEach(func() {
+			policy = "daily"
+		})
+
+		It("fails to set up", func() {
+			f.writeAptYml(&apt.Apt{UpdatePolicy: policy})
+			a := f.newApt()
+			Expect(a.Setup()).To(MatchError("update daily in apt.yml is not always, never or a duration such as 6h"))
+		})
+	})
+})
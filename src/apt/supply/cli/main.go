@@ -67,7 +67,7 @@ func main() {
 		os.Exit(13)
 	}
 
-	supplier := supply.New(stager, a, logger)
+	supplier := supply.New(stager, a, command, logger)
 
 	if err := supplier.Run(); err != nil {
 		logger.Error("Error running supply: %s", err.Error())
@@ -0,0 +1,109 @@
+package supply
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// aptRoot is how values in the env section of apt.yml refer to the install
+// root.
+const aptRoot = "${APT_ROOT}"
+
+// aptRootPath matches a path under the install root in a value, which ends
+// at whitespace, quotes or a path list separator.
+var aptRootPath = regexp.MustCompile(`\$\{APT_ROOT\}[^\s:;,"']*`)
+
+// linkTargets are the dep dirs that the links section of apt.yml can link
+// into.
+var linkTargets = map[string]bool{"bin": true, "include": true, "lib": true}
+
+// stagerPathVars are built by the stager from the dep dirs, setting them in
+// the env section would replace the dirs of every buildpack.
+var stagerPathVars = map[string]string{
+	"PATH":            "bin",
+	"LD_LIBRARY_PATH": "lib",
+	"LIBRARY_PATH":    "lib",
+	"CPATH":           "include",
+	"PKG_CONFIG_PATH": "pkgconfig",
+}
+
+var profileEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`")
+
+// applyCustomEnv links the dirs listed under links in apt.yml and exports
+// the variables listed under env, for later buildpacks and at runtime. Both
+// are checked against the installed packages first, so that a package that
+// moved its files fails staging instead of the app.
+func (s *Supplier) applyCustomEnv() error {
+	links := s.Apt.Links()
+	env := s.Apt.Env()
+	if len(links) == 0 && len(env) == 0 {
+		return nil
+	}
+
+	aptDir := s.aptDir()
+	dirs := sortedKeys(links)
+	names := sortedKeys(env)
+
+	var problems []string
+	for _, dir := range dirs {
+		clean := filepath.Clean(dir)
+		if !linkTargets[links[dir]] {
+			problems = append(problems, fmt.Sprintf("links %s: %s is not one of bin, include, lib", dir, links[dir]))
+		} else if filepath.IsAbs(dir) || clean == ".." || strings.HasPrefix(clean, "../") {
+			problems = append(problems, fmt.Sprintf("links %s: must be relative to the install root", dir))
+		} else if info, err := os.Stat(filepath.Join(aptDir, clean)); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("links %s: no such dir in the installed packages", dir))
+		}
+	}
+	for _, name := range names {
+		if depDir, found := stagerPathVars[name]; found {
+			problems = append(problems, fmt.Sprintf("env %s: is built from the %s dirs of all buildpacks, add a links entry instead", name, depDir))
+			continue
+		}
+		for _, path := range aptRootPath.FindAllString(env[name], -1) {
+			if _, err := os.Lstat(strings.Replace(path, aptRoot, aptDir, 1)); err != nil {
+				problems = append(problems, fmt.Sprintf("env %s: %s does not exist in the installed packages", name, path))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("apt.yml has env or links entries that do not match the installed packages:\n%s", strings.Join(problems, "\n"))
+	}
+
+	for _, dir := range dirs {
+		s.Log.Debug("Linking %s into %s", dir, links[dir])
+		if err := s.Stager.LinkDirectoryInDepDir(filepath.Join(aptDir, filepath.Clean(dir)), links[dir]); err != nil {
+			return err
+		}
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	runtimeAptDir := filepath.Join("$DEPS_DIR", s.Stager.DepsIdx(), "apt")
+	var script strings.Builder
+	for _, name := range names {
+		if err := s.Stager.WriteEnvFile(name, strings.ReplaceAll(env[name], aptRoot, aptDir)); err != nil {
+			return err
+		}
+		fmt.Fprintf(&script, "export %s=\"%s\"\n", name, strings.ReplaceAll(profileEscaper.Replace(env[name]), aptRoot, runtimeAptDir))
+	}
+
+	// sorts after the profile.d scripts of the buildpack, so that apt.yml
+	// can override the variables they export
+	return s.Stager.WriteProfileD("apt_yml_env.sh", script.String())
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,72 @@
+package supply_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/supply"
+
+	"github.com/golang/mock/gomock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Env and links from apt.yml", func() {
+	var (
+		f        *supplierFixture
+		supplier *supply.Supplier
+	)
+
+	BeforeEach(func() {
+		f = newSupplierFixture("0")
+		Expect(os.MkdirAll(filepath.Join(f.aptDir, "opt", "vendor", "bin"), 0755)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(f.aptDir, "usr", "lib", "jvm", "java-17-openjdk-amd64", "bin"), 0755)).To(Succeed())
+
+		supplier = f.newSupplier()
+	})
+
+	It("links the listed dirs after the standard ones", func() {
+		f.links = map[string]string{"opt/vendor/bin": "bin", "usr/lib/jvm/java-17-openjdk-amd64/bin/": "bin"}
+
+		gomock.InOrder(
+			f.mockStager.EXPECT().LinkDirectoryInDepDir(filepath.Join(f.aptDir, "usr", "lib"), "lib"),
+			f.mockStager.EXPECT().LinkDirectoryInDepDir(filepath.Join(f.aptDir, "opt", "vendor", "bin"), "bin"),
+			f.mockStager.EXPECT().LinkDirectoryInDepDir(filepath.Join(f.aptDir, "usr", "lib", "jvm", "java-17-openjdk-amd64", "bin"), "bin"),
+		)
+
+		Expect(supplier.Run()).To(Succeed())
+	})
+
+	It("exports the listed variables with the install root filled in", func() {
+		f.env = map[string]string{
+			"JAVA_HOME":   "${APT_ROOT}/usr/lib/jvm/java-17-openjdk-amd64",
+			"VENDOR_OPTS": `--home=${APT_ROOT}/opt/vendor --name="$HOME"`,
+		}
+
+		f.mockStager.EXPECT().LinkDirectoryInDepDir(gomock.Any(), gomock.Any()).AnyTimes()
+		f.mockStager.EXPECT().WriteEnvFile("JAVA_HOME", f.aptDir+"/usr/lib/jvm/java-17-openjdk-amd64")
+		f.mockStager.EXPECT().WriteEnvFile("VENDOR_OPTS", `--home=`+f.aptDir+`/opt/vendor --name="$HOME"`)
+		f.mockStager.EXPECT().WriteProfileD("apt_yml_env.sh",
+			"export JAVA_HOME=\"$DEPS_DIR/0/apt/usr/lib/jvm/java-17-openjdk-amd64\"\n"+
+				"export VENDOR_OPTS=\"--home=$DEPS_DIR/0/apt/opt/vendor --name=\\\"$HOME\\\"\"\n")
+
+		Expect(supplier.Run()).To(Succeed())
+	})
+
+	It("fails listing the entries that do not match the installed packages", func() {
+		f.links = map[string]string{"opt/missing/bin": "bin", "opt/vendor/bin": "share", "../etc": "lib"}
+		f.env = map[string]string{
+			"PATH":        "${APT_ROOT}/opt/vendor/bin",
+			"MAGICK_HOME": "${APT_ROOT}/usr/lib/ImageMagick:/usr/lib/ImageMagick",
+		}
+		f.mockStager.EXPECT().LinkDirectoryInDepDir(gomock.Any(), gomock.Any()).AnyTimes()
+
+		Expect(supplier.Run()).To(MatchError("apt.yml has env or links entries that do not match the installed packages:\n" +
+			"links ../etc: must be relative to the install root\n" +
+			"links opt/missing/bin: no such dir in the installed packages\n" +
+			"links opt/vendor/bin: share is not one of bin, include, lib\n" +
+			"env MAGICK_HOME: ${APT_ROOT}/usr/lib/ImageMagick does not exist in the installed packages\n" +
+			"env PATH: is built from the bin dirs of all buildpacks, add a links entry instead"))
+	})
+})
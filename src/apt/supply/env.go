@@ -0,0 +1,131 @@
+package supply
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// runtimeEnvVar is a search path variable of a language runtime or library
+// that finds files installed by packages through it. Patterns are globs
+// relative to the apt dir, {triplet} stands for each multiarch triplet.
+type runtimeEnvVar struct {
+	name     string
+	patterns []string
+	// fallback is the value a runtime uses when the variable is unset, which
+	// setting it would otherwise replace
+	fallback string
+	// keepDefault appends an empty entry, which man reads as its default path
+	keepDefault bool
+}
+
+var runtimeEnvVars = []runtimeEnvVar{
+	{name: "PYTHONPATH", patterns: []string{"usr/lib/python3/dist-packages", "usr/lib/python3.*/dist-packages"}},
+	{name: "PERL5LIB", patterns: []string{"usr/share/perl5", "usr/lib/{triplet}/perl5/*", "usr/share/perl/*", "usr/lib/{triplet}/perl/*"}},
+	{name: "GI_TYPELIB_PATH", patterns: []string{"usr/lib/{triplet}/girepository-1.0", "usr/lib/girepository-1.0"}},
+	{name: "XDG_DATA_DIRS", patterns: []string{"usr/share"}, fallback: "/usr/local/share:/usr/share"},
+	{name: "MANPATH", patterns: []string{"usr/share/man"}, keepDefault: true},
+	{name: "CLASSPATH", patterns: []string{"usr/share/java/*.jar"}},
+}
+
+// exportRuntimeEnv adds the search paths of the installed packages to the
+// variables in runtimeEnvVars, for later buildpacks and at runtime, unless
+// they are switched off under runtime_env in apt.yml.
+func (s *Supplier) exportRuntimeEnv(triplets []string) error {
+	switches := s.Apt.EnvSwitches()
+
+	var unknown []string
+	for name := range switches {
+		if !isRuntimeEnvVar(name) {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		s.Log.Warning("Ignoring runtime_env entries in apt.yml that the buildpack does not export: %s", strings.Join(unknown, ", "))
+	}
+
+	aptDir := s.aptDir()
+
+	var script strings.Builder
+	for _, envVar := range runtimeEnvVars {
+		if enabled, set := switches[envVar.name]; set && !enabled {
+			continue
+		}
+
+		paths := s.runtimeEnvPaths(envVar, triplets)
+		if len(paths) == 0 {
+			continue
+		}
+
+		runtimeAptDir := filepath.Join("$DEPS_DIR", s.Stager.DepsIdx(), "apt")
+		stagingPaths := make([]string, len(paths))
+		runtimePaths := make([]string, len(paths))
+		for i, path := range paths {
+			stagingPaths[i] = filepath.Join(aptDir, path)
+			runtimePaths[i] = filepath.Join(runtimeAptDir, path)
+		}
+
+		current := os.Getenv(envVar.name)
+		if current == "" {
+			current = envVar.fallback
+		}
+		if current != "" || envVar.keepDefault {
+			stagingPaths = append(stagingPaths, current)
+		}
+		if err := s.Stager.WriteEnvFile(envVar.name, strings.Join(stagingPaths, ":")); err != nil {
+			return err
+		}
+
+		rest := fmt.Sprintf("${%s:+:$%s}", envVar.name, envVar.name)
+		if envVar.fallback != "" {
+			rest = fmt.Sprintf(":${%s:-%s}", envVar.name, envVar.fallback)
+		} else if envVar.keepDefault {
+			rest = fmt.Sprintf(":$%s", envVar.name)
+		}
+		fmt.Fprintf(&script, "export %s=\"%s%s\"\n", envVar.name, strings.Join(runtimePaths, ":"), rest)
+		s.Log.Debug("Adding %s to %s", strings.Join(paths, ", "), envVar.name)
+	}
+
+	if script.Len() == 0 {
+		return nil
+	}
+	return s.Stager.WriteProfileD("apt_env.sh", script.String())
+}
+
+// runtimeEnvPaths returns the paths relative to the apt dir that match the
+// patterns of envVar, those of the native triplet first.
+func (s *Supplier) runtimeEnvPaths(envVar runtimeEnvVar, triplets []string) []string {
+	var paths []string
+	for _, pattern := range envVar.patterns {
+		expanded := []string{pattern}
+		if strings.Contains(pattern, "{triplet}") {
+			expanded = nil
+			for i := len(triplets) - 1; i >= 0; i-- {
+				expanded = append(expanded, strings.ReplaceAll(pattern, "{triplet}", triplets[i]))
+			}
+		}
+
+		for _, glob := range expanded {
+			matches, _ := filepath.Glob(filepath.Join(s.aptDir(), glob))
+			for _, match := range matches {
+				rel, err := filepath.Rel(s.aptDir(), match)
+				if err == nil {
+					paths = append(paths, rel)
+				}
+			}
+		}
+	}
+	return paths
+}
+
+func isRuntimeEnvVar(name string) bool {
+	for _, envVar := range runtimeEnvVars {
+		if envVar.name == name {
+			return true
+		}
+	}
+	return false
+}
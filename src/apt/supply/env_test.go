@@ -0,0 +1,107 @@
+package supply_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/supply"
+
+	"github.com/golang/mock/gomock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Runtime environment", func() {
+	var (
+		f        *supplierFixture
+		supplier *supply.Supplier
+		envFiles map[string]string
+		profileD map[string]string
+	)
+
+	mkdir := func(path string) {
+		Expect(os.MkdirAll(filepath.Join(f.aptDir, path), 0755)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		f = newSupplierFixture("1")
+		for _, name := range []string{"PYTHONPATH", "PERL5LIB", "GI_TYPELIB_PATH", "XDG_DATA_DIRS", "MANPATH", "CLASSPATH"} {
+			GinkgoT().Setenv(name, "")
+		}
+
+		f.envSwitches = nil
+		envFiles = map[string]string{}
+		profileD = map[string]string{}
+
+		f.mockStager.EXPECT().LinkDirectoryInDepDir(gomock.Any(), gomock.Any()).AnyTimes()
+		f.mockStager.EXPECT().WriteEnvFile(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(name, value string) error {
+			envFiles[name] = value
+			return nil
+		})
+		f.mockStager.EXPECT().WriteProfileD(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(func(name, script string) error {
+			profileD[name] = script
+			return nil
+		})
+
+		supplier = f.newSupplier()
+	})
+
+	It("exports the search paths of installed language modules", func() {
+		mkdir("usr/lib/python3/dist-packages")
+		mkdir("usr/share/perl5")
+		mkdir("usr/lib/x86_64-linux-gnu/perl5/5.34")
+		mkdir("usr/lib/x86_64-linux-gnu/girepository-1.0")
+
+		Expect(supplier.Run()).To(Succeed())
+
+		Expect(envFiles).To(HaveKeyWithValue("PYTHONPATH", f.aptDir+"/usr/lib/python3/dist-packages"))
+		Expect(envFiles).To(HaveKeyWithValue("PERL5LIB", f.aptDir+"/usr/share/perl5:"+f.aptDir+"/usr/lib/x86_64-linux-gnu/perl5/5.34"))
+		Expect(envFiles).To(HaveKeyWithValue("GI_TYPELIB_PATH", f.aptDir+"/usr/lib/x86_64-linux-gnu/girepository-1.0"))
+		Expect(envFiles).To(HaveKeyWithValue("XDG_DATA_DIRS", f.aptDir+"/usr/share:/usr/local/share:/usr/share"))
+		Expect(envFiles).NotTo(HaveKey("MANPATH"))
+
+		Expect(profileD["apt_env.sh"]).To(Equal(
+			"export PYTHONPATH=\"$DEPS_DIR/1/apt/usr/lib/python3/dist-packages${PYTHONPATH:+:$PYTHONPATH}\"\n" +
+				"export PERL5LIB=\"$DEPS_DIR/1/apt/usr/share/perl5:$DEPS_DIR/1/apt/usr/lib/x86_64-linux-gnu/perl5/5.34${PERL5LIB:+:$PERL5LIB}\"\n" +
+				"export GI_TYPELIB_PATH=\"$DEPS_DIR/1/apt/usr/lib/x86_64-linux-gnu/girepository-1.0${GI_TYPELIB_PATH:+:$GI_TYPELIB_PATH}\"\n" +
+				"export XDG_DATA_DIRS=\"$DEPS_DIR/1/apt/usr/share:${XDG_DATA_DIRS:-/usr/local/share:/usr/share}\"\n"))
+	})
+
+	It("keeps the default man path and the current staging value", func() {
+		GinkgoT().Setenv("CLASSPATH", "/opt/app.jar")
+		mkdir("usr/share/man/man1")
+		mkdir("usr/share/java")
+		Expect(os.WriteFile(filepath.Join(f.aptDir, "usr", "share", "java", "foo.jar"), []byte("jar"), 0644)).To(Succeed())
+
+		Expect(supplier.Run()).To(Succeed())
+
+		Expect(envFiles).To(HaveKeyWithValue("MANPATH", f.aptDir+"/usr/share/man:"))
+		Expect(envFiles).To(HaveKeyWithValue("CLASSPATH", f.aptDir+"/usr/share/java/foo.jar:/opt/app.jar"))
+		Expect(profileD["apt_env.sh"]).To(ContainSubstring("export MANPATH=\"$DEPS_DIR/1/apt/usr/share/man:$MANPATH\"\n"))
+	})
+
+	Context("when apt.yml switches variables off", func() {
+		BeforeEach(func() {
+			f.envSwitches = map[string]bool{"PYTHONPATH": false, "XDG_DATA_DIRS": false, "PERL5LIB": true, "LUA_PATH": false}
+		})
+
+		It("does not export them and warns about unknown ones", func() {
+			mkdir("usr/lib/python3/dist-packages")
+			mkdir("usr/share/perl5")
+
+			Expect(supplier.Run()).To(Succeed())
+
+			Expect(envFiles).NotTo(HaveKey("PYTHONPATH"))
+			Expect(envFiles).NotTo(HaveKey("XDG_DATA_DIRS"))
+			Expect(envFiles).To(HaveKey("PERL5LIB"))
+			Expect(f.buffer.String()).To(ContainSubstring("Ignoring runtime_env entries in apt.yml that the buildpack does not export: LUA_PATH"))
+		})
+	})
+
+	It("exports nothing when no package installs into these layouts", func() {
+		Expect(supplier.Run()).To(Succeed())
+		Expect(envFiles).To(BeEmpty())
+		Expect(profileD).To(BeEmpty())
+	})
+})
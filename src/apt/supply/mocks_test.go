@@ -5,6 +5,7 @@
 package supply_test
 
 import (
+	exec "os/exec"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -61,6 +62,20 @@ func (mr *MockStagerMockRecorder) DepDir() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DepDir", reflect.TypeOf((*MockStager)(nil).DepDir))
 }
 
+// DepsIdx mocks base method.
+func (m *MockStager) DepsIdx() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DepsIdx")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// DepsIdx indicates an expected call of DepsIdx.
+func (mr *MockStagerMockRecorder) DepsIdx() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DepsIdx", reflect.TypeOf((*MockStager)(nil).DepsIdx))
+}
+
 // LinkDirectoryInDepDir mocks base method.
 func (m *MockStager) LinkDirectoryInDepDir(arg0, arg1 string) error {
 	m.ctrl.T.Helper()
@@ -75,6 +90,72 @@ func (mr *MockStagerMockRecorder) LinkDirectoryInDepDir(arg0, arg1 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LinkDirectoryInDepDir", reflect.TypeOf((*MockStager)(nil).LinkDirectoryInDepDir), arg0, arg1)
 }
 
+// WriteEnvFile mocks base method.
+func (m *MockStager) WriteEnvFile(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteEnvFile", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteEnvFile indicates an expected call of WriteEnvFile.
+func (mr *MockStagerMockRecorder) WriteEnvFile(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteEnvFile", reflect.TypeOf((*MockStager)(nil).WriteEnvFile), arg0, arg1)
+}
+
+// WriteProfileD mocks base method.
+func (m *MockStager) WriteProfileD(arg0, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteProfileD", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteProfileD indicates an expected call of WriteProfileD.
+func (mr *MockStagerMockRecorder) WriteProfileD(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteProfileD", reflect.TypeOf((*MockStager)(nil).WriteProfileD), arg0, arg1)
+}
+
+// MockCommand is a mock of Command interface.
+type MockCommand struct {
+	ctrl     *gomock.Controller
+	recorder *MockCommandMockRecorder
+}
+
+// MockCommandMockRecorder is the mock recorder for MockCommand.
+type MockCommandMockRecorder struct {
+	mock *MockCommand
+}
+
+// NewMockCommand creates a new mock instance.
+func NewMockCommand(ctrl *gomock.Controller) *MockCommand {
+	mock := &MockCommand{ctrl: ctrl}
+	mock.recorder = &MockCommandMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockCommand) EXPECT() *MockCommandMockRecorder {
+	return m.recorder
+}
+
+// RunWithOutput mocks base method.
+func (m *MockCommand) RunWithOutput(arg0 *exec.Cmd) ([]byte, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RunWithOutput", arg0)
+	ret0, _ := ret[0].([]byte)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// RunWithOutput indicates an expected call of RunWithOutput.
+func (mr *MockCommandMockRecorder) RunWithOutput(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RunWithOutput", reflect.TypeOf((*MockCommand)(nil).RunWithOutput), arg0)
+}
+
 // MockApt is a mock of Apt interface.
 type MockApt struct {
 	ctrl     *gomock.Controller
@@ -126,6 +207,34 @@ func (mr *MockAptMockRecorder) AddRepos() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddRepos", reflect.TypeOf((*MockApt)(nil).AddRepos))
 }
 
+// Architectures mocks base method.
+func (m *MockApt) Architectures() []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Architectures")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// Architectures indicates an expected call of Architectures.
+func (mr *MockAptMockRecorder) Architectures() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Architectures", reflect.TypeOf((*MockApt)(nil).Architectures))
+}
+
+// BuildPackagesDir mocks base method.
+func (m *MockApt) BuildPackagesDir() string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "BuildPackagesDir")
+	ret0, _ := ret[0].(string)
+	return ret0
+}
+
+// BuildPackagesDir indicates an expected call of BuildPackagesDir.
+func (mr *MockAptMockRecorder) BuildPackagesDir() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BuildPackagesDir", reflect.TypeOf((*MockApt)(nil).BuildPackagesDir))
+}
+
 // Clean mocks base method.
 func (m *MockApt) Clean() error {
 	m.ctrl.T.Helper()
@@ -154,6 +263,34 @@ func (mr *MockAptMockRecorder) DownloadAll() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DownloadAll", reflect.TypeOf((*MockApt)(nil).DownloadAll))
 }
 
+// Env mocks base method.
+func (m *MockApt) Env() map[string]string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Env")
+	ret0, _ := ret[0].(map[string]string)
+	return ret0
+}
+
+// Env indicates an expected call of Env.
+func (mr *MockAptMockRecorder) Env() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Env", reflect.TypeOf((*MockApt)(nil).Env))
+}
+
+// EnvSwitches mocks base method.
+func (m *MockApt) EnvSwitches() map[string]bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnvSwitches")
+	ret0, _ := ret[0].(map[string]bool)
+	return ret0
+}
+
+// EnvSwitches indicates an expected call of EnvSwitches.
+func (mr *MockAptMockRecorder) EnvSwitches() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnvSwitches", reflect.TypeOf((*MockApt)(nil).EnvSwitches))
+}
+
 // HasClean mocks base method.
 func (m *MockApt) HasClean() bool {
 	m.ctrl.T.Helper()
@@ -210,6 +347,34 @@ func (mr *MockAptMockRecorder) InstallAll() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InstallAll", reflect.TypeOf((*MockApt)(nil).InstallAll))
 }
 
+// Links mocks base method.
+func (m *MockApt) Links() map[string]string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Links")
+	ret0, _ := ret[0].(map[string]string)
+	return ret0
+}
+
+// Links indicates an expected call of Links.
+func (mr *MockAptMockRecorder) Links() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Links", reflect.TypeOf((*MockApt)(nil).Links))
+}
+
+// ReportSizes mocks base method.
+func (m *MockApt) ReportSizes() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReportSizes")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReportSizes indicates an expected call of ReportSizes.
+func (mr *MockAptMockRecorder) ReportSizes() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReportSizes", reflect.TypeOf((*MockApt)(nil).ReportSizes))
+}
+
 // Setup mocks base method.
 func (m *MockApt) Setup() error {
 	m.ctrl.T.Helper()
@@ -237,3 +402,45 @@ func (mr *MockAptMockRecorder) Update() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockApt)(nil).Update))
 }
+
+// WriteLicenseReport mocks base method.
+func (m *MockApt) WriteLicenseReport(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteLicenseReport", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteLicenseReport indicates an expected call of WriteLicenseReport.
+func (mr *MockAptMockRecorder) WriteLicenseReport(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteLicenseReport", reflect.TypeOf((*MockApt)(nil).WriteLicenseReport), arg0)
+}
+
+// WriteLock mocks base method.
+func (m *MockApt) WriteLock(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteLock", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteLock indicates an expected call of WriteLock.
+func (mr *MockAptMockRecorder) WriteLock(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteLock", reflect.TypeOf((*MockApt)(nil).WriteLock), arg0)
+}
+
+// WriteSBOM mocks base method.
+func (m *MockApt) WriteSBOM(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "WriteSBOM", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// WriteSBOM indicates an expected call of WriteSBOM.
+func (mr *MockAptMockRecorder) WriteSBOM(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WriteSBOM", reflect.TypeOf((*MockApt)(nil).WriteSBOM), arg0)
+}
@@ -0,0 +1,61 @@
+package supply
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/cloudfoundry/libbuildpack"
+)
+
+// multiarchTriplets maps dpkg architectures to the multiarch triplets that
+// name their library dirs.
+var multiarchTriplets = map[string]string{
+	"amd64":   "x86_64-linux-gnu",
+	"arm64":   "aarch64-linux-gnu",
+	"armel":   "arm-linux-gnueabi",
+	"armhf":   "arm-linux-gnueabihf",
+	"i386":    "i386-linux-gnu",
+	"ppc64el": "powerpc64le-linux-gnu",
+	"riscv64": "riscv64-linux-gnu",
+	"s390x":   "s390x-linux-gnu",
+}
+
+// triplets returns the multiarch triplets of the stack's architectures, the
+// native one last so that its libraries win when linked into one dir.
+func (s *Supplier) triplets() []string {
+	var triplets []string
+	for _, arch := range s.Apt.Architectures() {
+		if triplet, ok := multiarchTriplets[arch]; ok {
+			triplets = append([]string{triplet}, triplets...)
+		} else {
+			s.Log.Warning("Unknown multiarch triplet for architecture %s, its libraries are not linked", arch)
+		}
+	}
+	return triplets
+}
+
+// exportForeignLibraryPath appends the library dirs of foreign architectures
+// to LD_LIBRARY_PATH at runtime. Their libraries are linked into the lib dir
+// as well, but where a name clashes with a native library only the native one
+// is linked, and the dynamic loader needs the foreign dirs to find the other.
+func (s *Supplier) exportForeignLibraryPath(triplets []string) error {
+	if len(triplets) < 2 {
+		return nil
+	}
+
+	var dirs []string
+	for _, triplet := range triplets[:len(triplets)-1] {
+		for _, dir := range []string{"usr/lib/" + triplet, "lib/" + triplet} {
+			if exists, err := libbuildpack.FileExists(filepath.Join(s.aptDir(), dir)); err != nil {
+				return err
+			} else if exists {
+				dirs = append(dirs, filepath.Join("$DEPS_DIR", s.Stager.DepsIdx(), "apt", dir))
+			}
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	return s.Stager.WriteProfileD("apt_multiarch.sh", "export LD_LIBRARY_PATH=\"${LD_LIBRARY_PATH:+$LD_LIBRARY_PATH:}"+strings.Join(dirs, ":")+"\"\n")
+}
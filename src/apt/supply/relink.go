@@ -0,0 +1,87 @@
+package supply
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// relinkAbsoluteSymlinks rewrites symlinks that packages ship with absolute
+// targets, which would resolve against the stack's root filesystem, to
+// relative targets inside root when the target was installed there. Targets
+// that are missing from root are looked up in the fallback dirs, where
+// packages that another install dir depends on were installed.
+// Symlinks that resolve nowhere afterwards are reported.
+func (s *Supplier) relinkAbsoluteSymlinks(root string, fallbacks ...string) error {
+	var links []string
+
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == root {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if d.Type()&os.ModeSymlink != 0 {
+			links = append(links, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, link := range links {
+		target, err := os.Readlink(link)
+		if err != nil {
+			return err
+		}
+
+		path := target
+		if !filepath.IsAbs(target) {
+			rel, err := filepath.Rel(root, filepath.Join(filepath.Dir(link), target))
+			if err != nil || strings.HasPrefix(rel, "..") {
+				continue
+			}
+			path = "/" + rel
+		}
+
+		inTree := ""
+		for i, dir := range append([]string{root}, fallbacks...) {
+			if _, err := os.Lstat(filepath.Join(dir, path)); err == nil {
+				if i > 0 || filepath.IsAbs(target) {
+					inTree = filepath.Join(dir, path)
+				}
+				break
+			}
+		}
+		if inTree == "" {
+			continue
+		}
+
+		relative, err := filepath.Rel(filepath.Dir(link), inTree)
+		if err != nil {
+			return err
+		}
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+		if err := os.Symlink(relative, link); err != nil {
+			return err
+		}
+		s.Log.Debug("Relinked %s from %s to %s", link, target, relative)
+	}
+
+	var dangling []string
+	for _, link := range links {
+		if _, err := os.Stat(link); err != nil {
+			target, _ := os.Readlink(link)
+			dangling = append(dangling, fmt.Sprintf("  %s -> %s", strings.TrimPrefix(link, root+"/"), target))
+		}
+	}
+	if len(dangling) > 0 {
+		s.Log.Warning("These symlinks of the installed packages point to files that do not exist:\n%s", strings.Join(dangling, "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package supply_test
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/supply"
+
+	"github.com/golang/mock/gomock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Relinking absolute symlinks", func() {
+	var (
+		f        *supplierFixture
+		libDir   string
+		supplier *supply.Supplier
+	)
+
+	BeforeEach(func() {
+		f = newSupplierFixture("0")
+		libDir = filepath.Join(f.aptDir, "usr", "lib", "x86_64-linux-gnu")
+
+		Expect(os.MkdirAll(libDir, 0755)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(libDir, "libfoo.so.1"), []byte("elf"), 0644)).To(Succeed())
+
+		f.mockStager.EXPECT().LinkDirectoryInDepDir(gomock.Any(), gomock.Any()).AnyTimes()
+
+		f.mockCommand.EXPECT().RunWithOutput(gomock.Any()).AnyTimes()
+		supplier = f.newSupplier()
+	})
+
+	It("points absolute symlinks at the installed target", func() {
+		Expect(os.Symlink("/usr/lib/x86_64-linux-gnu/libfoo.so.1", filepath.Join(libDir, "libfoo.so"))).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(f.aptDir, "usr", "bin"), 0755)).To(Succeed())
+		Expect(os.Symlink("/usr/lib/x86_64-linux-gnu", filepath.Join(f.aptDir, "usr", "bin", "libs"))).To(Succeed())
+
+		Expect(supplier.Run()).To(Succeed())
+
+		Expect(os.Readlink(filepath.Join(libDir, "libfoo.so"))).To(Equal("libfoo.so.1"))
+		Expect(os.Readlink(filepath.Join(f.aptDir, "usr", "bin", "libs"))).To(Equal("../lib/x86_64-linux-gnu"))
+		Expect(f.buffer.String()).NotTo(ContainSubstring("do not exist"))
+	})
+
+	It("leaves relative symlinks alone", func() {
+		Expect(os.Symlink("libfoo.so.1", filepath.Join(libDir, "libfoo.so"))).To(Succeed())
+
+		Expect(supplier.Run()).To(Succeed())
+
+		Expect(os.Readlink(filepath.Join(libDir, "libfoo.so"))).To(Equal("libfoo.so.1"))
+	})
+
+	It("reports symlinks whose target is not installed", func() {
+		Expect(os.Symlink("/etc/alternatives/does-not-exist", filepath.Join(libDir, "libbar.so"))).To(Succeed())
+		Expect(os.Symlink("libbaz.so.1", filepath.Join(libDir, "libbaz.so"))).To(Succeed())
+
+		Expect(supplier.Run()).To(Succeed())
+
+		Expect(os.Readlink(filepath.Join(libDir, "libbar.so"))).To(Equal("/etc/alternatives/does-not-exist"))
+		Expect(f.buffer.String()).To(ContainSubstring("These symlinks of the installed packages point to files that do not exist:"))
+		Expect(f.buffer.String()).To(ContainSubstring("usr/lib/x86_64-linux-gnu/libbar.so -> /etc/alternatives/does-not-exist"))
+		Expect(f.buffer.String()).To(ContainSubstring("usr/lib/x86_64-linux-gnu/libbaz.so -> libbaz.so.1"))
+	})
+})
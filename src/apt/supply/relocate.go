@@ -0,0 +1,225 @@
+package supply
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	usrPath = regexp.MustCompile(`/usr(/[\w.+@-]+)*`)
+
+	// configPatterns name the text files that locate headers and libraries
+	// by absolute path
+	configPatterns = []string{"*.pc", "*.la", "*Config.cmake", "*-config.cmake", "*Targets*.cmake"}
+	binDirs        = map[string]bool{"bin": true, "sbin": true, "usr/bin": true, "usr/sbin": true}
+)
+
+// relocatePaths rewrites /usr paths in pkg-config, libtool, cmake and
+// *-config files under root to root or else the first fallback dir where the
+// referenced file was installed. Shell *-config scripts may run at runtime
+// too, so they name the apt dir through $DEPS_DIR. Scripts whose interpreter
+// was installed to usr/bin look it up on the PATH instead, which has that dir
+// both while staging and at runtime.
+func (s *Supplier) relocatePaths(root string, fallbacks ...string) error {
+	roots := append([]string{root}, fallbacks...)
+	relocated := 0
+
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == root {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		inBinDir := binDirs[filepath.Dir(rel)]
+		config := isConfigFile(d.Name()) || (inBinDir && strings.HasSuffix(d.Name(), "-config"))
+		script := inBinDir && info.Mode()&0111 != 0
+		if !config && !script {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if bytes.IndexByte(content, 0) >= 0 {
+			return nil
+		}
+
+		var updated []byte
+		if config && inBinDir && isShellScript(content) {
+			updated = s.relocateText(content, roots, true)
+		} else if config && inBinDir && bytes.HasPrefix(content, []byte("#!")) {
+			shebang, body := splitShebang(content)
+			updated = append(portableShebang(shebang, roots), s.relocateText(body, roots, false)...)
+		} else if config {
+			updated = s.relocateText(content, roots, false)
+		} else if bytes.HasPrefix(content, []byte("#!")) {
+			updated = portableShebang(content, roots)
+		} else {
+			return nil
+		}
+
+		if bytes.Equal(content, updated) {
+			return nil
+		}
+		relocated++
+		return os.WriteFile(path, updated, info.Mode().Perm())
+	})
+	if err != nil {
+		return err
+	}
+
+	if relocated > 0 {
+		s.Log.Debug("Relocated /usr paths in %d files under %s", relocated, root)
+	}
+	return nil
+}
+
+func isConfigFile(name string) bool {
+	for _, pattern := range configPatterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isShellScript tells whether content is run by a POSIX shell, which expands
+// $DEPS_DIR when it runs.
+func isShellScript(content []byte) bool {
+	line, _ := splitShebang(content)
+	fields := strings.Fields(strings.TrimPrefix(string(line), "#!"))
+	if !bytes.HasPrefix(line, []byte("#!")) || len(fields) == 0 {
+		return false
+	}
+
+	shell := filepath.Base(fields[0])
+	if shell == "env" && len(fields) > 1 {
+		shell = fields[1]
+	}
+	return shell == "sh" || shell == "bash" || shell == "dash"
+}
+
+// runtimeAptDir names the apt dir through $DEPS_DIR, which is set at runtime,
+// falling back to the deps dir of staging.
+func (s *Supplier) runtimeAptDir() string {
+	return fmt.Sprintf("${DEPS_DIR:-%s}/%s/apt", filepath.Dir(s.Stager.DepDir()), s.Stager.DepsIdx())
+}
+
+// portableShebang makes a script run its interpreter through env when the
+// interpreter was installed to usr/bin of one of roots, as an absolute path
+// would only be valid while staging.
+func portableShebang(content []byte, roots []string) []byte {
+	line, rest := splitShebang(content)
+
+	fields := strings.Fields(string(line[2:]))
+	if len(fields) == 0 || filepath.Dir(fields[0]) != "/usr/bin" {
+		return content
+	}
+	if !slices.ContainsFunc(roots, func(root string) bool {
+		_, err := os.Stat(filepath.Join(root, fields[0]))
+		return err == nil
+	}) {
+		return content
+	}
+
+	shebang := "#!/usr/bin/env " + filepath.Base(fields[0])
+	if len(fields) > 1 {
+		// the kernel passes all arguments as one, env -S splits them
+		shebang = "#!/usr/bin/env -S " + filepath.Base(fields[0]) + " " + strings.Join(fields[1:], " ")
+	}
+	return append([]byte(shebang), rest...)
+}
+
+// splitShebang splits content before the end of its first line.
+func splitShebang(content []byte) ([]byte, []byte) {
+	end := bytes.IndexByte(content, '\n')
+	if end < 0 {
+		end = len(content)
+	}
+	return content[:end:end], content[end:]
+}
+
+// relocateText prefixes the first of roots that contains it to each /usr
+// path in content, naming the apt dir through $DEPS_DIR if runtime is set.
+// Paths following a word character, as in ${prefix}/usr, are part of a
+// longer path and left alone, except in -I and -L flags.
+func (s *Supplier) relocateText(content []byte, roots []string, runtime bool) []byte {
+	var out bytes.Buffer
+	last := 0
+	for _, match := range usrPath.FindAllIndex(content, -1) {
+		start, end := match[0], match[1]
+		if !pathStart(content, start) {
+			continue
+		}
+
+		path := string(content[start:end])
+		i := slices.IndexFunc(roots, func(root string) bool {
+			_, err := os.Lstat(filepath.Join(root, path))
+			return err == nil
+		})
+		if i < 0 {
+			continue
+		}
+
+		out.Write(content[last:start])
+		if runtime && roots[i] == s.aptDir() {
+			if singleQuoted(content[:start]) {
+				// variables do not expand in single quotes, close them around it
+				out.WriteString("'\"" + s.runtimeAptDir() + "\"'" + path)
+			} else {
+				out.WriteString(s.runtimeAptDir() + path)
+			}
+		} else {
+			out.WriteString(roots[i] + path)
+		}
+		last = end
+	}
+	out.Write(content[last:])
+
+	return out.Bytes()
+}
+
+// singleQuoted tells whether the end of content is inside single quotes of
+// the shell line it ends with.
+func singleQuoted(content []byte) bool {
+	line := content[bytes.LastIndexByte(content, '\n')+1:]
+	quoted := false
+	for i, c := range line {
+		if c == '\'' && (quoted || i == 0 || line[i-1] != '\\') {
+			quoted = !quoted
+		}
+	}
+	return quoted
+}
+
+func pathStart(content []byte, start int) bool {
+	if start == 0 {
+		return true
+	}
+	if start >= 2 && content[start-2] == '-' && (content[start-1] == 'I' || content[start-1] == 'L') {
+		return true
+	}
+
+	c := content[start-1]
+	return !(c == '_' || c == '.' || c == '/' || c == '$' || c == '}' ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9'))
+}
@@ -0,0 +1,112 @@
+package supply_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/supply"
+
+	"github.com/golang/mock/gomock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Relocating /usr paths", func() {
+	var (
+		f        *supplierFixture
+		libDir   string
+		supplier *supply.Supplier
+	)
+
+	writeFile := func(path, content string, mode os.FileMode) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(content), mode)).To(Succeed())
+	}
+
+	readFile := func(path string) string {
+		content, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return string(content)
+	}
+
+	BeforeEach(func() {
+		f = newSupplierFixture("0")
+		libDir = filepath.Join(f.aptDir, "usr", "lib", "x86_64-linux-gnu")
+
+		writeFile(filepath.Join(libDir, "libfoo.so.1"), "elf", 0644)
+		writeFile(filepath.Join(f.aptDir, "usr", "include", "foo", "foo.h"), "", 0644)
+		writeFile(filepath.Join(f.aptDir, "usr", "bin", "perl"), "elf", 0755)
+
+		f.mockStager.EXPECT().LinkDirectoryInDepDir(gomock.Any(), gomock.Any()).AnyTimes()
+
+		f.mockCommand.EXPECT().RunWithOutput(gomock.Any()).AnyTimes()
+		supplier = f.newSupplier()
+	})
+
+	It("relocates pkg-config files that name libdir directly", func() {
+		pc := filepath.Join(libDir, "pkgconfig", "foo.pc")
+		writeFile(pc, "prefix=/usr\nlibdir=/usr/lib/x86_64-linux-gnu\nincludedir=${prefix}/include\n"+
+			"Libs: -L/usr/lib/x86_64-linux-gnu -lfoo -L/usr/lib/missing\nCflags: -I/usr/include/foo\n", 0644)
+
+		Expect(supplier.Run()).To(Succeed())
+
+		Expect(readFile(pc)).To(Equal("prefix=" + f.aptDir + "/usr\nlibdir=" + libDir + "\nincludedir=${prefix}/include\n" +
+			"Libs: -L" + libDir + " -lfoo -L/usr/lib/missing\nCflags: -I" + f.aptDir + "/usr/include/foo\n"))
+	})
+
+	It("relocates libtool archives", func() {
+		la := filepath.Join(libDir, "libfoo.la")
+		writeFile(la, "dlname='libfoo.so.1'\nlibdir='/usr/lib/x86_64-linux-gnu'\ndependency_libs=' /usr/lib/x86_64-linux-gnu/libfoo.so.1 -lm'\n", 0644)
+
+		Expect(supplier.Run()).To(Succeed())
+
+		Expect(readFile(la)).To(Equal("dlname='libfoo.so.1'\nlibdir='" + libDir + "'\ndependency_libs=' " + libDir + "/libfoo.so.1 -lm'\n"))
+	})
+
+	It("relocates cmake configs and -config scripts", func() {
+		cmake := filepath.Join(libDir, "cmake", "Foo", "FooConfig.cmake")
+		writeFile(cmake, "set(FOO_INCLUDE_DIRS \"/usr/include/foo\")\nset(FOO_PREFIX \"${_IMPORT_PREFIX}/usr/include\")\n", 0644)
+		config := filepath.Join(f.aptDir, "usr", "bin", "foo-config")
+		writeFile(config, "#!/usr/bin/perl\nprint '-I/usr/include/foo';\n", 0755)
+
+		Expect(supplier.Run()).To(Succeed())
+
+		Expect(readFile(cmake)).To(Equal("set(FOO_INCLUDE_DIRS \"" + f.aptDir + "/usr/include/foo\")\nset(FOO_PREFIX \"${_IMPORT_PREFIX}/usr/include\")\n"))
+		Expect(readFile(config)).To(Equal("#!/usr/bin/env perl\nprint '-I" + f.aptDir + "/usr/include/foo';\n"))
+	})
+
+	It("names the apt dir through $DEPS_DIR in shell -config scripts, which may run at runtime", func() {
+		config := filepath.Join(f.aptDir, "usr", "bin", "foo-config")
+		writeFile(config, "#!/bin/sh\necho -I/usr/include/foo\nlibdir='/usr/lib/x86_64-linux-gnu'\n", 0755)
+
+		Expect(supplier.Run()).To(Succeed())
+
+		runtimeAptDir := "${DEPS_DIR:-" + f.depsDir + "}/0/apt"
+		Expect(readFile(config)).To(Equal("#!/bin/sh\necho -I" + runtimeAptDir + "/usr/include/foo\n" +
+			"libdir=''\"" + runtimeAptDir + "\"'/usr/lib/x86_64-linux-gnu'\n"))
+
+		script := "DEPS_DIR=/home/vcap/deps; " + readFile(config)
+		Expect(exec.Command("sh", "-c", script).Output()).To(Equal([]byte("-I/home/vcap/deps/0/apt/usr/include/foo\n")))
+	})
+
+	It("runs scripts with an installed interpreter through env", func() {
+		script := filepath.Join(f.aptDir, "usr", "bin", "foo")
+		writeFile(script, "#!/usr/bin/perl -w\nuse lib '/usr/include';\n", 0755)
+		plain := filepath.Join(f.aptDir, "usr", "bin", "baz")
+		writeFile(plain, "#!/usr/bin/perl\n", 0755)
+		other := filepath.Join(f.aptDir, "usr", "bin", "bar")
+		writeFile(other, "#!/usr/bin/python3\n", 0755)
+
+		Expect(supplier.Run()).To(Succeed())
+
+		Expect(readFile(script)).To(Equal("#!/usr/bin/env -S perl -w\nuse lib '/usr/include';\n"))
+		Expect(readFile(plain)).To(Equal("#!/usr/bin/env perl\n"))
+		Expect(readFile(other)).To(Equal("#!/usr/bin/python3\n"))
+
+		info, err := os.Stat(script)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+	})
+})
@@ -2,8 +2,8 @@ package supply
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
-	"strings"
 
 	"github.com/cloudfoundry/libbuildpack"
 )
@@ -11,7 +11,14 @@ import (
 type Stager interface {
 	LinkDirectoryInDepDir(string, string) error
 	DepDir() string
+	DepsIdx() string
 	CacheDir() string
+	WriteEnvFile(string, string) error
+	WriteProfileD(string, string) error
+}
+
+type Command interface {
+	RunWithOutput(*exec.Cmd) ([]byte, error)
 }
 
 type Apt interface {
@@ -22,22 +29,33 @@ type Apt interface {
 	AddRepos() error
 	Update() error
 	DownloadAll() error
+	WriteLock(string) error
 	InstallAll() error
+	ReportSizes() error
+	WriteSBOM(string) error
+	WriteLicenseReport(string) error
+	Architectures() []string
+	BuildPackagesDir() string
+	EnvSwitches() map[string]bool
+	Env() map[string]string
+	Links() map[string]string
 	Clean() error
 	HasClean() bool
 }
 
 type Supplier struct {
-	Stager Stager
-	Log    *libbuildpack.Logger
-	Apt    Apt
+	Stager  Stager
+	Log     *libbuildpack.Logger
+	Apt     Apt
+	Command Command
 }
 
-func New(stager Stager, apt Apt, logger *libbuildpack.Logger) *Supplier {
+func New(stager Stager, apt Apt, command Command, logger *libbuildpack.Logger) *Supplier {
 	return &Supplier{
-		Stager: stager,
-		Log:    logger,
-		Apt:    apt,
+		Stager:  stager,
+		Log:     logger,
+		Apt:     apt,
+		Command: command,
 	}
 }
 
@@ -77,25 +95,84 @@ func (s *Supplier) Run() error {
 		return err
 	}
 
+	if err := s.Apt.WriteLock(filepath.Join(s.Stager.DepDir(), "apt.lock")); err != nil {
+		return err
+	}
+
 	s.Log.BeginStep("Installing apt packages")
 	if err := s.Apt.InstallAll(); err != nil {
 		return err
 	}
 
+	if err := s.Apt.ReportSizes(); err != nil {
+		return err
+	}
+
+	s.Log.Debug("Relinking absolute symlinks")
+	if err := s.relinkAbsoluteSymlinks(s.aptDir()); err != nil {
+		return err
+	}
+
+	s.Log.Debug("Relocating /usr paths")
+	if err := s.relocatePaths(s.aptDir()); err != nil {
+		return err
+	}
+
+	// build packages may depend on packages installed into the droplet,
+	// which they find in the apt dir
+	if buildDir := s.Apt.BuildPackagesDir(); buildDir != "" {
+		if err := s.relinkAbsoluteSymlinks(buildDir, s.aptDir()); err != nil {
+			return err
+		}
+		if err := s.relocatePaths(buildDir, s.aptDir()); err != nil {
+			return err
+		}
+	}
+
+	s.Log.Debug("Running package triggers")
+	if err := s.runTriggers(); err != nil {
+		return err
+	}
+
+	if err := s.Apt.WriteSBOM(s.Stager.DepDir()); err != nil {
+		return err
+	}
+
+	if err := s.Apt.WriteLicenseReport(s.Stager.DepDir()); err != nil {
+		return err
+	}
+
 	s.Log.Debug("Creating Symlinks")
-	return s.createSymlinks()
+	if err := s.createSymlinks(); err != nil {
+		return err
+	}
+
+	return s.applyCustomEnv()
 }
 
 func (s *Supplier) createSymlinks() error {
-	for _, dirs := range [][]string{
-		{"usr/bin", "bin"},
-		{"usr/lib", "lib"},
-		{"usr/lib/i386-linux-gnu", "lib"},
-		{"usr/lib/x86_64-linux-gnu", "lib"},
-		{"lib/x86_64-linux-gnu", "lib"},
-		{"usr/include", "include"},
-	} {
-		dest := filepath.Join(s.Stager.DepDir(), "apt", dirs[0])
+	triplets := s.triplets()
+
+	if err := s.linkInstallDir(s.aptDir(), triplets); err != nil {
+		return err
+	}
+
+	if buildDir := s.Apt.BuildPackagesDir(); buildDir != "" {
+		if err := s.linkBuildPackages(buildDir, triplets); err != nil {
+			return err
+		}
+	}
+
+	if err := s.exportForeignLibraryPath(triplets); err != nil {
+		return err
+	}
+
+	return s.exportRuntimeEnv(triplets)
+}
+
+func (s *Supplier) linkInstallDir(root string, triplets []string) error {
+	for _, dirs := range linkDirs(triplets) {
+		dest := filepath.Join(root, dirs[0])
 		if exists, err := libbuildpack.FileExists(dest); err != nil {
 			return err
 		} else if exists {
@@ -105,14 +182,10 @@ func (s *Supplier) createSymlinks() error {
 		}
 	}
 
-	// copy pkgconfig files instead of linking, then modify
-	// the copies to point to the DepDir-based path
-	for _, dirs := range [][]string{
-		{"usr/lib/i386-linux-gnu/pkgconfig", "pkgconfig"},
-		{"usr/lib/x86_64-linux-gnu/pkgconfig", "pkgconfig"},
-		{"usr/lib/pkgconfig", "pkgconfig"},
-	} {
-		dest := filepath.Join(s.Stager.DepDir(), "apt", dirs[0])
+	// copy pkgconfig files instead of linking, relocatePaths has
+	// already pointed them at root
+	for _, dirs := range pkgconfigDirs(triplets) {
+		dest := filepath.Join(root, dirs[0])
 		if exists, err := libbuildpack.FileExists(dest); err != nil {
 			return err
 		} else if exists {
@@ -125,14 +198,7 @@ func (s *Supplier) createSymlinks() error {
 				return err
 			}
 			for _, file := range files {
-				//TODO: better way to copy a file?
-				contents, err := os.ReadFile(filepath.Join(dest, file.Name()))
-				if err != nil {
-					return err
-				}
-				newContents := strings.Replace(string(contents[:]), "prefix=/usr\n", "prefix="+filepath.Join(s.Stager.DepDir(), "apt", "usr")+"\n", -1)
-				err = os.WriteFile(filepath.Join(destDir, file.Name()), []byte(newContents), 0666)
-				if err != nil {
+				if err := libbuildpack.CopyFile(filepath.Join(dest, file.Name()), filepath.Join(destDir, file.Name())); err != nil {
 					return err
 				}
 			}
@@ -141,3 +207,59 @@ func (s *Supplier) createSymlinks() error {
 
 	return nil
 }
+
+// linkBuildPackages links the files of the build packages into the bin, lib,
+// include and pkgconfig dirs of the deps dir, from which libbuildpack builds
+// the staging env of later buildpacks next to the dirs of all other
+// buildpacks. The packages of the droplet win where both provide a file. The
+// links point into the cache dir, which is not part of the droplet, so they
+// dangle once staging is done and lookups through PATH and the library path
+// skip them at runtime.
+func (s *Supplier) linkBuildPackages(buildDir string, triplets []string) error {
+	for _, dirs := range append(linkDirs(triplets), pkgconfigDirs(triplets)...) {
+		files, err := os.ReadDir(filepath.Join(buildDir, dirs[0]))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		destDir := filepath.Join(s.Stager.DepDir(), dirs[1])
+		if err := os.MkdirAll(destDir, 0755); err != nil {
+			return err
+		}
+		for _, file := range files {
+			link := filepath.Join(destDir, file.Name())
+			if _, err := os.Lstat(link); err == nil {
+				continue
+			}
+			if err := os.Symlink(filepath.Join(buildDir, dirs[0], file.Name()), link); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// linkDirs pairs the dirs of an install dir with the dirs of the deps dir
+// they are linked into.
+func linkDirs(triplets []string) [][]string {
+	dirs := [][]string{
+		{"usr/bin", "bin"},
+		{"usr/lib", "lib"},
+	}
+	for _, triplet := range triplets {
+		dirs = append(dirs, []string{"usr/lib/" + triplet, "lib"}, []string{"lib/" + triplet, "lib"})
+	}
+	return append(dirs, []string{"usr/include", "include"})
+}
+
+// pkgconfigDirs pairs the pkg-config dirs of an install dir with the
+// pkgconfig dir of the deps dir.
+func pkgconfigDirs(triplets []string) [][]string {
+	var dirs [][]string
+	for _, triplet := range triplets {
+		dirs = append(dirs, []string{"usr/lib/" + triplet + "/pkgconfig", "pkgconfig"})
+	}
+	return append(dirs, []string{"usr/lib/pkgconfig", "pkgconfig"})
+}
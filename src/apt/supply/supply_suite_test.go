@@ -1,13 +1,92 @@
 package supply_test
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/supply"
+
+	"github.com/cloudfoundry/libbuildpack"
+	"github.com/golang/mock/gomock"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-
-	"testing"
 )
 
 func TestSupply(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Supply Suite")
 }
+
+// noRuntimeEnv switches off the export of every search path variable.
+var noRuntimeEnv = map[string]bool{
+	"PYTHONPATH":      false,
+	"PERL5LIB":        false,
+	"GI_TYPELIB_PATH": false,
+	"XDG_DATA_DIRS":   false,
+	"MANPATH":         false,
+	"CLASSPATH":       false,
+}
+
+// supplierFixture is what a test of the supplier stages with: a dep dir to
+// install into and mocks of the stager, apt and commands. Every step of apt
+// does nothing, and the apt.yml settings apt returns are those in the
+// fields of the fixture.
+type supplierFixture struct {
+	depsDir     string
+	depDir      string
+	aptDir      string
+	mockStager  *MockStager
+	mockApt     *MockApt
+	mockCommand *MockCommand
+	buffer      *bytes.Buffer
+	envSwitches map[string]bool
+	env         map[string]string
+	links       map[string]string
+}
+
+// newSupplierFixture creates the dep dir at index depsIdx of a deps dir that
+// is removed after the test.
+func newSupplierFixture(depsIdx string) *supplierFixture {
+	depsDir, err := os.MkdirTemp("", "apt.depsdir")
+	Expect(err).ToNot(HaveOccurred())
+	DeferCleanup(os.RemoveAll, depsDir)
+
+	mockCtrl := gomock.NewController(GinkgoT())
+	f := &supplierFixture{
+		depsDir:     depsDir,
+		depDir:      filepath.Join(depsDir, depsIdx),
+		aptDir:      filepath.Join(depsDir, depsIdx, "apt"),
+		mockStager:  NewMockStager(mockCtrl),
+		mockApt:     NewMockApt(mockCtrl),
+		mockCommand: NewMockCommand(mockCtrl),
+		buffer:      new(bytes.Buffer),
+		envSwitches: noRuntimeEnv,
+	}
+	Expect(os.MkdirAll(f.depDir, 0755)).To(Succeed())
+
+	f.mockStager.EXPECT().DepDir().AnyTimes().Return(f.depDir)
+	f.mockStager.EXPECT().DepsIdx().AnyTimes().Return(depsIdx)
+	f.mockApt.EXPECT().Setup().AnyTimes()
+	f.mockApt.EXPECT().HasKeys().AnyTimes()
+	f.mockApt.EXPECT().HasRepos().AnyTimes()
+	f.mockApt.EXPECT().HasClean().AnyTimes()
+	f.mockApt.EXPECT().Update().AnyTimes()
+	f.mockApt.EXPECT().DownloadAll().AnyTimes()
+	f.mockApt.EXPECT().WriteLock(gomock.Any()).AnyTimes()
+	f.mockApt.EXPECT().InstallAll().AnyTimes()
+	f.mockApt.EXPECT().ReportSizes().AnyTimes()
+	f.mockApt.EXPECT().WriteSBOM(gomock.Any()).AnyTimes()
+	f.mockApt.EXPECT().WriteLicenseReport(gomock.Any()).AnyTimes()
+	f.mockApt.EXPECT().Architectures().AnyTimes().Return([]string{"amd64"})
+	f.mockApt.EXPECT().BuildPackagesDir().AnyTimes()
+	f.mockApt.EXPECT().EnvSwitches().AnyTimes().DoAndReturn(func() map[string]bool { return f.envSwitches })
+	f.mockApt.EXPECT().Env().AnyTimes().DoAndReturn(func() map[string]string { return f.env })
+	f.mockApt.EXPECT().Links().AnyTimes().DoAndReturn(func() map[string]string { return f.links })
+	return f
+}
+
+func (f *supplierFixture) newSupplier() *supply.Supplier {
+	return supply.New(f.mockStager, f.mockApt, f.mockCommand, libbuildpack.NewLogger(f.buffer))
+}
@@ -1,6 +1,7 @@
 package supply_test
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 
@@ -19,13 +20,17 @@ import (
 
 var _ = Describe("Supply", func() {
 	var (
-		depDir     string
-		supplier   *supply.Supplier
-		logger     *libbuildpack.Logger
-		mockCtrl   *gomock.Controller
-		mockStager *MockStager
-		mockApt    *MockApt
-		buffer     *bytes.Buffer
+		depsDir     string
+		depDir      string
+		supplier    *supply.Supplier
+		logger      *libbuildpack.Logger
+		mockCtrl    *gomock.Controller
+		mockStager  *MockStager
+		mockApt     *MockApt
+		mockCommand *MockCommand
+		buffer      *bytes.Buffer
+		archs       []string
+		buildDir    string
 	)
 
 	BeforeEach(func() {
@@ -35,15 +40,25 @@ var _ = Describe("Supply", func() {
 
 		mockCtrl = gomock.NewController(GinkgoT())
 		mockStager = NewMockStager(mockCtrl)
-		depDir, err = os.MkdirTemp("", "apt.depdir")
+		depsDir, err = os.MkdirTemp("", "apt.depsdir")
 		Expect(err).ToNot(HaveOccurred())
+		depDir = filepath.Join(depsDir, "0")
+		Expect(os.Mkdir(depDir, 0755)).To(Succeed())
 		mockStager.EXPECT().DepDir().AnyTimes().Return(depDir)
 		mockApt = NewMockApt(mockCtrl)
-		DeferCleanup(os.RemoveAll, depDir)
+		mockCommand = NewMockCommand(mockCtrl)
+		archs = []string{"amd64"}
+		buildDir = ""
+		DeferCleanup(os.RemoveAll, depsDir)
 	})
 
 	JustBeforeEach(func() {
-		supplier = supply.New(mockStager, mockApt, logger)
+		mockApt.EXPECT().Architectures().AnyTimes().Return(archs)
+		mockApt.EXPECT().BuildPackagesDir().AnyTimes().Return(buildDir)
+		mockApt.EXPECT().EnvSwitches().AnyTimes()
+		mockApt.EXPECT().Env().AnyTimes()
+		mockApt.EXPECT().Links().AnyTimes()
+		supplier = supply.New(mockStager, mockApt, mockCommand, logger)
 	})
 
 	allowAllAptMethods := func() {
@@ -53,7 +68,11 @@ var _ = Describe("Supply", func() {
 		mockApt.EXPECT().HasClean().AnyTimes()
 		mockApt.EXPECT().Update().AnyTimes()
 		mockApt.EXPECT().DownloadAll().AnyTimes()
+		mockApt.EXPECT().WriteLock(gomock.Any()).AnyTimes()
 		mockApt.EXPECT().InstallAll().AnyTimes()
+		mockApt.EXPECT().ReportSizes().AnyTimes()
+		mockApt.EXPECT().WriteSBOM(gomock.Any()).AnyTimes()
+		mockApt.EXPECT().WriteLicenseReport(gomock.Any()).AnyTimes()
 	}
 
 	allowAllDepLinkingMethods := func() {
@@ -69,12 +88,31 @@ var _ = Describe("Supply", func() {
 				mockApt.EXPECT().HasClean(),
 				mockApt.EXPECT().Update(),
 				mockApt.EXPECT().DownloadAll(),
+				mockApt.EXPECT().WriteLock(filepath.Join(depDir, "apt.lock")),
 				mockApt.EXPECT().InstallAll(),
+				mockApt.EXPECT().ReportSizes(),
+				mockApt.EXPECT().WriteSBOM(depDir),
+				mockApt.EXPECT().WriteLicenseReport(depDir),
 			)
 			allowAllDepLinkingMethods()
 			Expect(supplier.Run()).To(Succeed())
 		})
 
+		It("stops when the installed packages exceed max_size", func() {
+			gomock.InOrder(
+				mockApt.EXPECT().Setup(),
+				mockApt.EXPECT().HasKeys(),
+				mockApt.EXPECT().HasRepos(),
+				mockApt.EXPECT().HasClean(),
+				mockApt.EXPECT().Update(),
+				mockApt.EXPECT().DownloadAll(),
+				mockApt.EXPECT().WriteLock(filepath.Join(depDir, "apt.lock")),
+				mockApt.EXPECT().InstallAll(),
+				mockApt.EXPECT().ReportSizes().Return(errors.New("installed packages take 2.0G")),
+			)
+			Expect(supplier.Run()).To(MatchError("installed packages take 2.0G"))
+		})
+
 		It("symlinks the apt packages", func() {
 			allowAllAptMethods()
 			Expect(os.MkdirAll(filepath.Join(depDir, "apt", "usr", "bin"), 0755)).To(Succeed())
@@ -86,6 +124,155 @@ var _ = Describe("Supply", func() {
 			Expect(supplier.Run()).To(Succeed())
 		})
 
+		Context("on an arm64 stack", func() {
+			BeforeEach(func() {
+				archs = []string{"arm64"}
+			})
+
+			It("symlinks the library dirs of its multiarch triplet", func() {
+				allowAllAptMethods()
+				libDir := filepath.Join(depDir, "apt", "usr", "lib", "aarch64-linux-gnu")
+				Expect(os.MkdirAll(filepath.Join(libDir, "pkgconfig"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(libDir, "pkgconfig", "foo.pc"), []byte("Name: foo\n"), 0644)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(depDir, "apt", "usr", "lib", "x86_64-linux-gnu"), 0755)).To(Succeed())
+
+				mockStager.EXPECT().LinkDirectoryInDepDir(filepath.Join(depDir, "apt", "usr", "lib"), "lib")
+				mockStager.EXPECT().LinkDirectoryInDepDir(libDir, "lib")
+
+				Expect(supplier.Run()).To(Succeed())
+				Expect(filepath.Join(depDir, "pkgconfig", "foo.pc")).To(BeAnExistingFile())
+			})
+		})
+
+		Context("when the stack has foreign architectures", func() {
+			BeforeEach(func() {
+				archs = []string{"amd64", "i386"}
+			})
+
+			It("symlinks their library dirs before the native ones", func() {
+				allowAllAptMethods()
+				Expect(os.MkdirAll(filepath.Join(depDir, "apt", "usr", "lib", "i386-linux-gnu"), 0755)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(depDir, "apt", "usr", "lib", "x86_64-linux-gnu"), 0755)).To(Succeed())
+
+				mockStager.EXPECT().DepsIdx().AnyTimes().Return("0")
+				mockStager.EXPECT().WriteProfileD(gomock.Any(), gomock.Any())
+				gomock.InOrder(
+					mockStager.EXPECT().LinkDirectoryInDepDir(filepath.Join(depDir, "apt", "usr", "lib"), "lib"),
+					mockStager.EXPECT().LinkDirectoryInDepDir(filepath.Join(depDir, "apt", "usr", "lib", "i386-linux-gnu"), "lib"),
+					mockStager.EXPECT().LinkDirectoryInDepDir(filepath.Join(depDir, "apt", "usr", "lib", "x86_64-linux-gnu"), "lib"),
+				)
+
+				Expect(supplier.Run()).To(Succeed())
+			})
+		})
+
+		Context("when apt.yml adds foreign architectures", func() {
+			BeforeEach(func() {
+				archs = []string{"amd64", "i386"}
+			})
+
+			It("appends their library dirs to the runtime library path", func() {
+				allowAllAptMethods()
+				allowAllDepLinkingMethods()
+				Expect(os.MkdirAll(filepath.Join(depDir, "apt", "usr", "lib", "i386-linux-gnu"), 0755)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(depDir, "apt", "lib", "i386-linux-gnu"), 0755)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(depDir, "apt", "usr", "lib", "x86_64-linux-gnu"), 0755)).To(Succeed())
+
+				mockStager.EXPECT().DepsIdx().AnyTimes().Return("2")
+				mockStager.EXPECT().WriteProfileD("apt_multiarch.sh",
+					"export LD_LIBRARY_PATH=\"${LD_LIBRARY_PATH:+$LD_LIBRARY_PATH:}$DEPS_DIR/2/apt/usr/lib/i386-linux-gnu:$DEPS_DIR/2/apt/lib/i386-linux-gnu\"\n")
+
+				Expect(supplier.Run()).To(Succeed())
+			})
+		})
+
+		Context("when dpkg reports an unknown architecture", func() {
+			BeforeEach(func() {
+				archs = []string{"amd64", "mips64el"}
+			})
+
+			It("warns that its libraries are not linked", func() {
+				allowAllAptMethods()
+				allowAllDepLinkingMethods()
+
+				Expect(supplier.Run()).To(Succeed())
+				Expect(buffer.String()).To(ContainSubstring("Unknown multiarch triplet for architecture mips64el, its libraries are not linked"))
+			})
+		})
+
+		Context("when apt.yml has build packages", func() {
+			BeforeEach(func() {
+				cacheDir, err := os.MkdirTemp("", "apt.cachedir")
+				Expect(err).ToNot(HaveOccurred())
+				DeferCleanup(os.RemoveAll, cacheDir)
+				buildDir = filepath.Join(cacheDir, "apt", "build")
+
+				libDir := filepath.Join(depDir, "apt", "usr", "lib", "x86_64-linux-gnu")
+				Expect(os.MkdirAll(libDir, 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(libDir, "libfoo.so.1"), []byte("elf"), 0644)).To(Succeed())
+
+				buildLibDir := filepath.Join(buildDir, "usr", "lib", "x86_64-linux-gnu")
+				Expect(os.MkdirAll(filepath.Join(buildLibDir, "pkgconfig"), 0755)).To(Succeed())
+				Expect(os.Symlink("libfoo.so.1", filepath.Join(buildLibDir, "libfoo.so"))).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildLibDir, "pkgconfig", "foo.pc"), []byte("Cflags: -I/usr/include/foo\n"), 0644)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(buildDir, "usr", "include", "foo"), 0755)).To(Succeed())
+			})
+
+			It("adds them to the staging env of later buildpacks next to the dirs of other buildpacks", func() {
+				allowAllAptMethods()
+				mockCommand.EXPECT().RunWithOutput(gomock.Any()).AnyTimes()
+				stager := libbuildpack.NewStager([]string{filepath.Dir(buildDir), filepath.Dir(buildDir), depsDir, "0"}, logger, &libbuildpack.Manifest{})
+				mockStager.EXPECT().LinkDirectoryInDepDir(gomock.Any(), gomock.Any()).AnyTimes().DoAndReturn(stager.LinkDirectoryInDepDir)
+
+				Expect(os.MkdirAll(filepath.Join(buildDir, "usr", "bin"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(buildDir, "usr", "bin", "cc"), []byte("elf"), 0755)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(depsDir, "1", "bin"), 0755)).To(Succeed())
+
+				Expect(supplier.Run()).To(Succeed())
+
+				for _, name := range []string{"PATH", "LD_LIBRARY_PATH", "LIBRARY_PATH", "CPATH", "PKG_CONFIG_PATH"} {
+					GinkgoT().Setenv(name, os.Getenv(name))
+				}
+				Expect(stager.SetStagingEnvironment()).To(Succeed())
+
+				Expect(filepath.SplitList(os.Getenv("PATH"))).To(ContainElements(filepath.Join(depsDir, "0", "bin"), filepath.Join(depsDir, "1", "bin")))
+				Expect(os.Readlink(filepath.Join(depDir, "bin", "cc"))).To(Equal(filepath.Join(buildDir, "usr", "bin", "cc")))
+				Expect(filepath.SplitList(os.Getenv("CPATH"))).To(ContainElement(filepath.Join(depDir, "include")))
+				Expect(os.Readlink(filepath.Join(depDir, "include", "foo"))).To(Equal(filepath.Join(buildDir, "usr", "include", "foo")))
+				Expect(filepath.SplitList(os.Getenv("PKG_CONFIG_PATH"))).To(ContainElement(filepath.Join(depDir, "pkgconfig")))
+				Expect(os.Readlink(filepath.Join(depDir, "pkgconfig", "foo.pc"))).To(Equal(filepath.Join(buildDir, "usr", "lib", "x86_64-linux-gnu", "pkgconfig", "foo.pc")))
+				Expect(os.ReadFile(filepath.Join(depDir, "pkgconfig", "foo.pc"))).To(Equal([]byte("Cflags: -I" + buildDir + "/usr/include/foo\n")))
+			})
+
+			It("lets the packages of the droplet win where both provide a file", func() {
+				allowAllAptMethods()
+				mockCommand.EXPECT().RunWithOutput(gomock.Any()).AnyTimes()
+				mockStager.EXPECT().LinkDirectoryInDepDir(filepath.Join(depDir, "apt", "usr", "lib"), "lib")
+				mockStager.EXPECT().LinkDirectoryInDepDir(filepath.Join(depDir, "apt", "usr", "lib", "x86_64-linux-gnu"), "lib").DoAndReturn(func(string, string) error {
+					Expect(os.MkdirAll(filepath.Join(depDir, "lib"), 0755)).To(Succeed())
+					return os.Symlink("droplet", filepath.Join(depDir, "lib", "libfoo.so"))
+				})
+
+				Expect(supplier.Run()).To(Succeed())
+
+				Expect(os.Readlink(filepath.Join(depDir, "lib", "libfoo.so"))).To(Equal("droplet"))
+			})
+
+			It("points their symlinks at dependencies installed into the droplet", func() {
+				allowAllAptMethods()
+				mockCommand.EXPECT().RunWithOutput(gomock.Any()).AnyTimes()
+				allowAllDepLinkingMethods()
+
+				Expect(supplier.Run()).To(Succeed())
+
+				target, err := filepath.EvalSymlinks(filepath.Join(buildDir, "usr", "lib", "x86_64-linux-gnu", "libfoo.so"))
+				Expect(err).NotTo(HaveOccurred())
+				expected, err := filepath.EvalSymlinks(filepath.Join(depDir, "apt", "usr", "lib", "x86_64-linux-gnu", "libfoo.so.1"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(target).To(Equal(expected))
+			})
+		})
+
 		Context("Aptfile has keys", func() {
 			It("Calls AddKeys", func() {
 				gomock.InOrder(
@@ -96,7 +283,11 @@ var _ = Describe("Supply", func() {
 					mockApt.EXPECT().HasClean(),
 					mockApt.EXPECT().Update(),
 					mockApt.EXPECT().DownloadAll(),
+					mockApt.EXPECT().WriteLock(filepath.Join(depDir, "apt.lock")),
 					mockApt.EXPECT().InstallAll(),
+					mockApt.EXPECT().ReportSizes(),
+					mockApt.EXPECT().WriteSBOM(depDir),
+					mockApt.EXPECT().WriteLicenseReport(depDir),
 				)
 				allowAllDepLinkingMethods()
 				Expect(supplier.Run()).To(Succeed())
@@ -113,7 +304,11 @@ var _ = Describe("Supply", func() {
 					mockApt.EXPECT().HasClean(),
 					mockApt.EXPECT().Update(),
 					mockApt.EXPECT().DownloadAll(),
+					mockApt.EXPECT().WriteLock(filepath.Join(depDir, "apt.lock")),
 					mockApt.EXPECT().InstallAll(),
+					mockApt.EXPECT().ReportSizes(),
+					mockApt.EXPECT().WriteSBOM(depDir),
+					mockApt.EXPECT().WriteLicenseReport(depDir),
 				)
 				allowAllDepLinkingMethods()
 				Expect(supplier.Run()).To(Succeed())
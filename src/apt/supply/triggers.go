@@ -0,0 +1,242 @@
+package supply
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// triggerResult is what a trigger leaves behind: env vars pointing at the
+// caches it generated, relative to the apt dir, and the names of those env
+// vars whose files contain absolute staging paths.
+type triggerResult struct {
+	env      map[string]string
+	relocate map[string]bool
+}
+
+const fontsConf = `<?xml version="1.0"?>
+<!DOCTYPE fontconfig SYSTEM "urn:fontconfig:fonts.dtd">
+<fontconfig>
+  <include ignore_missing="yes">/etc/fonts/fonts.conf</include>
+  <dir prefix="relative">../../usr/share/fonts</dir>
+</fontconfig>
+`
+
+// runTriggers regenerates the caches that dpkg triggers would have built
+// had the packages been installed to the root filesystem, and exports the
+// env vars pointing at them for later buildpacks and at runtime.
+func (s *Supplier) runTriggers() error {
+	result := triggerResult{env: map[string]string{}, relocate: map[string]bool{}}
+
+	for _, trigger := range []func(*triggerResult) error{
+		s.ldconfig,
+		s.compileSchemas,
+		s.queryPixbufLoaders,
+		s.configureFonts,
+	} {
+		if err := trigger(&result); err != nil {
+			return err
+		}
+	}
+
+	if len(result.env) == 0 {
+		return nil
+	}
+
+	aptDir := s.aptDir()
+	runtimeAptDir := filepath.Join("$DEPS_DIR", s.Stager.DepsIdx(), "apt")
+
+	names := make([]string, 0, len(result.env))
+	for name := range result.env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var script strings.Builder
+	for _, name := range names {
+		if err := s.Stager.WriteEnvFile(name, filepath.Join(aptDir, result.env[name])); err != nil {
+			return err
+		}
+		if !result.relocate[name] {
+			fmt.Fprintf(&script, "export %s=%s\n", name, filepath.Join(runtimeAptDir, result.env[name]))
+			continue
+		}
+
+		// caches listing absolute paths are generated during staging, point a
+		// private copy at the location of the deps dir at runtime rather than
+		// editing the droplet
+		fmt.Fprintf(&script, "export %s=$(mktemp)\n", name)
+		fmt.Fprintf(&script, "sed \"s|%s/|%s/|g\" \"%s\" > \"$%s\"\n", aptDir, runtimeAptDir, filepath.Join(runtimeAptDir, result.env[name]), name)
+	}
+
+	return s.Stager.WriteProfileD("apt_triggers.sh", script.String())
+}
+
+// ldconfig creates the shared library symlinks that the ldconfig trigger
+// would have created.
+func (s *Supplier) ldconfig(result *triggerResult) error {
+	var dirs []string
+	for _, dir := range s.libDirs() {
+		if libs, _ := filepath.Glob(filepath.Join(dir, "*.so*")); len(libs) > 0 {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	_, err := s.runTool("ldconfig", []string{"sbin/ldconfig", "usr/sbin/ldconfig"}, nil, append([]string{"-n"}, dirs...)...)
+	return err
+}
+
+// compileSchemas compiles installed GSettings schemas.
+func (s *Supplier) compileSchemas(result *triggerResult) error {
+	schemaDir := filepath.Join("usr", "share", "glib-2.0", "schemas")
+	if schemas, _ := filepath.Glob(filepath.Join(s.aptDir(), schemaDir, "*.gschema.xml")); len(schemas) == 0 {
+		return nil
+	}
+
+	output, err := s.runTool("glib-compile-schemas", []string{"usr/bin/glib-compile-schemas"}, nil, filepath.Join(s.aptDir(), schemaDir))
+	if err != nil || output == nil {
+		return err
+	}
+
+	result.env["GSETTINGS_SCHEMA_DIR"] = schemaDir
+	return nil
+}
+
+// queryPixbufLoaders writes a gdk-pixbuf loaders cache listing both the
+// installed loaders and those of the stack.
+func (s *Supplier) queryPixbufLoaders(result *triggerResult) error {
+	loaderDirs, _ := filepath.Glob(filepath.Join(s.aptDir(), "usr", "lib", "*", "gdk-pixbuf-2.0", "*", "loaders"))
+	var loaders []string
+	for _, dir := range loaderDirs {
+		found, _ := filepath.Glob(filepath.Join(dir, "*.so"))
+		loaders = append(loaders, found...)
+	}
+	if len(loaders) == 0 {
+		return nil
+	}
+
+	stackLoaders, _ := filepath.Glob("/usr/lib/*/gdk-pixbuf-2.0/*/loaders/*.so")
+	loaders = append(loaders, stackLoaders...)
+
+	paths := []string{"usr/lib/*/gdk-pixbuf-2.0/gdk-pixbuf-query-loaders", "usr/bin/gdk-pixbuf-query-loaders"}
+	output, err := s.runTool("gdk-pixbuf-query-loaders", paths, nil, loaders...)
+	if err != nil || output == nil {
+		return err
+	}
+
+	cache, err := filepath.Rel(s.aptDir(), filepath.Join(filepath.Dir(loaderDirs[0]), "loaders.cache"))
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.aptDir(), cache), output, 0644); err != nil {
+		return err
+	}
+
+	result.env["GDK_PIXBUF_MODULE_FILE"] = cache
+	result.relocate["GDK_PIXBUF_MODULE_FILE"] = true
+	return nil
+}
+
+// configureFonts writes a fontconfig configuration adding the installed fonts
+// to those of the stack. No font cache is built, it would name the staging
+// paths of the fonts, so fontconfig caches them on first use at runtime.
+func (s *Supplier) configureFonts(result *triggerResult) error {
+	if exists, err := dirHasFiles(filepath.Join(s.aptDir(), "usr", "share", "fonts")); err != nil || !exists {
+		return err
+	}
+
+	conf := filepath.Join("etc", "fonts", "fonts.conf")
+	if err := os.MkdirAll(filepath.Join(s.aptDir(), "etc", "fonts"), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(s.aptDir(), conf), []byte(fontsConf), 0644); err != nil {
+		return err
+	}
+	result.env["FONTCONFIG_FILE"] = conf
+	return nil
+}
+
+// runTool runs a tool installed with the packages, or else the one of the
+// stack, with the installed libraries on its library path. When neither
+// provides the tool a warning is logged and nil output returned.
+func (s *Supplier) runTool(name string, paths, env []string, args ...string) ([]byte, error) {
+	tool := s.findTool(name, paths)
+	if tool == "" {
+		s.Log.Warning("Not running the %s trigger, %s is not available", name, name)
+		return nil, nil
+	}
+
+	libraryPath := s.libDirs()
+	if existing := os.Getenv("LD_LIBRARY_PATH"); existing != "" {
+		libraryPath = append(libraryPath, existing)
+	}
+
+	cmd := exec.Command(tool, args...)
+	cmd.Env = append(os.Environ(), "LD_LIBRARY_PATH="+strings.Join(libraryPath, ":"))
+	cmd.Env = append(cmd.Env, env...)
+
+	output, err := s.Command.RunWithOutput(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run %s\n\n%s\n\n%s", name, output, err)
+	}
+	if output == nil {
+		output = []byte{}
+	}
+	return output, nil
+}
+
+func (s *Supplier) findTool(name string, paths []string) string {
+	for _, root := range []string{s.aptDir(), "/"} {
+		for _, path := range paths {
+			if matches, _ := filepath.Glob(filepath.Join(root, path)); len(matches) > 0 {
+				return matches[0]
+			}
+		}
+	}
+
+	if tool, err := exec.LookPath(name); err == nil {
+		return tool
+	}
+	return ""
+}
+
+// libDirs are the existing library dirs of the apt dir.
+func (s *Supplier) libDirs() []string {
+	var dirs []string
+	for _, pattern := range []string{"usr/lib", "usr/lib/*-linux-gnu*", "lib/*-linux-gnu*"} {
+		matches, _ := filepath.Glob(filepath.Join(s.aptDir(), pattern))
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.IsDir() {
+				dirs = append(dirs, match)
+			}
+		}
+	}
+	return dirs
+}
+
+func (s *Supplier) aptDir() string {
+	return filepath.Join(s.Stager.DepDir(), "apt")
+}
+
+func dirHasFiles(dir string) (bool, error) {
+	found := false
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if os.IsNotExist(err) {
+			return filepath.SkipDir
+		} else if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found, err
+}
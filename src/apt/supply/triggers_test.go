@@ -0,0 +1,115 @@
+package supply_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/cloudfoundry/apt-buildpack/src/apt/supply"
+
+	"github.com/golang/mock/gomock"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Triggers", func() {
+	var (
+		f        *supplierFixture
+		supplier *supply.Supplier
+	)
+
+	writeFile := func(path, content string, mode os.FileMode) {
+		Expect(os.MkdirAll(filepath.Dir(path), 0755)).To(Succeed())
+		Expect(os.WriteFile(path, []byte(content), mode)).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		f = newSupplierFixture("3")
+		f.mockStager.EXPECT().LinkDirectoryInDepDir(gomock.Any(), gomock.Any()).AnyTimes()
+	})
+
+	JustBeforeEach(func() {
+		supplier = f.newSupplier()
+	})
+
+	It("does nothing when no package needs a trigger", func() {
+		Expect(supplier.Run()).To(Succeed())
+	})
+
+	It("creates shared library symlinks with ldconfig", func() {
+		libDir := filepath.Join(f.aptDir, "usr", "lib", "x86_64-linux-gnu")
+		writeFile(filepath.Join(libDir, "libfoo.so.1.0"), "elf", 0644)
+		writeFile(filepath.Join(f.aptDir, "sbin", "ldconfig"), "", 0755)
+
+		f.mockCommand.EXPECT().RunWithOutput(gomock.Any()).DoAndReturn(func(cmd *exec.Cmd) ([]byte, error) {
+			Expect(cmd.Args).To(Equal([]string{filepath.Join(f.aptDir, "sbin", "ldconfig"), "-n", libDir}))
+			Expect(cmd.Env).To(ContainElement(HavePrefix("LD_LIBRARY_PATH=" + filepath.Join(f.aptDir, "usr", "lib"))))
+			return nil, nil
+		})
+
+		Expect(supplier.Run()).To(Succeed())
+	})
+
+	It("compiles GSettings schemas", func() {
+		schemaDir := filepath.Join(f.aptDir, "usr", "share", "glib-2.0", "schemas")
+		writeFile(filepath.Join(schemaDir, "org.example.foo.gschema.xml"), "<schemalist/>", 0644)
+		writeFile(filepath.Join(f.aptDir, "usr", "bin", "glib-compile-schemas"), "", 0755)
+
+		f.mockCommand.EXPECT().RunWithOutput(gomock.Any()).DoAndReturn(func(cmd *exec.Cmd) ([]byte, error) {
+			Expect(cmd.Args).To(Equal([]string{filepath.Join(f.aptDir, "usr", "bin", "glib-compile-schemas"), schemaDir}))
+			return nil, nil
+		})
+		f.mockStager.EXPECT().WriteEnvFile("GSETTINGS_SCHEMA_DIR", schemaDir)
+		f.mockStager.EXPECT().WriteProfileD("apt_triggers.sh", "export GSETTINGS_SCHEMA_DIR=$DEPS_DIR/3/apt/usr/share/glib-2.0/schemas\n")
+
+		Expect(supplier.Run()).To(Succeed())
+	})
+
+	It("writes a gdk-pixbuf loaders cache relocated into a private copy at runtime", func() {
+		pixbufDir := filepath.Join(f.aptDir, "usr", "lib", "x86_64-linux-gnu", "gdk-pixbuf-2.0")
+		loader := filepath.Join(pixbufDir, "2.10.0", "loaders", "libpixbufloader-svg.so")
+		writeFile(loader, "elf", 0644)
+		writeFile(filepath.Join(pixbufDir, "gdk-pixbuf-query-loaders"), "", 0755)
+
+		f.mockCommand.EXPECT().RunWithOutput(gomock.Any()).DoAndReturn(func(cmd *exec.Cmd) ([]byte, error) {
+			Expect(cmd.Path).To(Equal(filepath.Join(pixbufDir, "gdk-pixbuf-query-loaders")))
+			Expect(cmd.Args[1]).To(Equal(loader))
+			return []byte(`"` + loader + `"` + "\n\"svg\" 6 \"gdk-pixbuf\" \"Scalable Vector Graphics\" \"LGPL\"\n"), nil
+		})
+		f.mockStager.EXPECT().WriteEnvFile("GDK_PIXBUF_MODULE_FILE", filepath.Join(pixbufDir, "2.10.0", "loaders.cache"))
+		f.mockStager.EXPECT().WriteProfileD("apt_triggers.sh",
+			"export GDK_PIXBUF_MODULE_FILE=$(mktemp)\n"+
+				"sed \"s|"+f.aptDir+"/|$DEPS_DIR/3/apt/|g\" \"$DEPS_DIR/3/apt/usr/lib/x86_64-linux-gnu/gdk-pixbuf-2.0/2.10.0/loaders.cache\" > \"$GDK_PIXBUF_MODULE_FILE\"\n")
+
+		Expect(supplier.Run()).To(Succeed())
+		Expect(os.ReadFile(filepath.Join(pixbufDir, "2.10.0", "loaders.cache"))).To(ContainSubstring(`"` + loader + `"`))
+	})
+
+	It("adds installed fonts to the fontconfig configuration without caching them at staging paths", func() {
+		writeFile(filepath.Join(f.aptDir, "usr", "share", "fonts", "truetype", "foo", "Foo.ttf"), "font", 0644)
+		writeFile(filepath.Join(f.aptDir, "usr", "bin", "fc-cache"), "", 0755)
+		fontsConf := filepath.Join(f.aptDir, "etc", "fonts", "fonts.conf")
+
+		f.mockCommand.EXPECT().RunWithOutput(gomock.Any()).Times(0)
+		f.mockStager.EXPECT().WriteEnvFile("FONTCONFIG_FILE", fontsConf)
+		f.mockStager.EXPECT().WriteProfileD("apt_triggers.sh", "export FONTCONFIG_FILE=$DEPS_DIR/3/apt/etc/fonts/fonts.conf\n")
+
+		Expect(supplier.Run()).To(Succeed())
+
+		conf, err := os.ReadFile(fontsConf)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(conf)).To(ContainSubstring(`<include ignore_missing="yes">/etc/fonts/fonts.conf</include>`))
+		Expect(string(conf)).To(ContainSubstring(`<dir prefix="relative">../../usr/share/fonts</dir>`))
+		Expect(string(conf)).NotTo(ContainSubstring("cachedir"))
+	})
+
+	It("fails when a trigger fails", func() {
+		writeFile(filepath.Join(f.aptDir, "usr", "share", "glib-2.0", "schemas", "org.example.foo.gschema.xml"), "<schemalist>", 0644)
+		writeFile(filepath.Join(f.aptDir, "usr", "bin", "glib-compile-schemas"), "", 0755)
+
+		f.mockCommand.EXPECT().RunWithOutput(gomock.Any()).Return([]byte("invalid schema"), exec.ErrNotFound)
+
+		Expect(supplier.Run()).To(MatchError(ContainSubstring("failed to run glib-compile-schemas\n\ninvalid schema")))
+	})
+})